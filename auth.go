@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIToken grants access to the web API within its quota.
+type APIToken struct {
+	Name  string `json:"name"` // shown in logs, never the token itself
+	Token string `json:"token"`
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// Quota limits what a token may run. Zero means unlimited.
+type Quota struct {
+	MaxConcurrency int `json:"max_concurrency"` // workers per run
+	MaxRequests    int `json:"max_requests"`    // requests per run
+	RunsPerHour    int `json:"runs_per_hour"`
+}
+
+// apiError is an error with the HTTP status to answer with.
+type apiError struct {
+	Code       int
+	Msg        string
+	RetryAfter time.Duration // 429 only
+}
+
+func (e *apiError) Error() string {
+	return e.Msg
+}
+
+// writeError answers with err's status, or 400 for plain errors.
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+	http.Error(w, apiErr.Msg, apiErr.Code)
+}
+
+type tokenKey struct{}
+
+// tokenFrom returns the token that authenticated r, or nil when the API is
+// open.
+func tokenFrom(r *http.Request) *APIToken {
+	t, _ := r.Context().Value(tokenKey{}).(*APIToken)
+	return t
+}
+
+// lookupToken finds the token sent as "Authorization: Bearer <token>".
+func (c *ServerConfig) lookupToken(r *http.Request) *APIToken {
+	value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || value == "" {
+		return nil
+	}
+	for i := range c.Tokens {
+		if subtle.ConstantTimeCompare([]byte(c.Tokens[i].Token), []byte(value)) == 1 {
+			return &c.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// requireToken rejects API calls without a valid token (401) once tokens
+// are configured.
+func (c *ServerConfig) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(c.Tokens) == 0 || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := c.lookupToken(r)
+		if token == nil {
+			fmt.Printf("[AUTH] Rejected %s %s from %s: missing or invalid token\n", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mechanic"`)
+			http.Error(w, "Missing or invalid API token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, token)))
+	})
+}
+
+// runLimiter counts runs per token over a sliding hour.
+type runLimiter struct {
+	mu   sync.Mutex
+	runs map[string][]time.Time
+}
+
+var runs = &runLimiter{runs: make(map[string][]time.Time)}
+
+// Take records a run for name, or returns how long until one is allowed.
+func (l *runLimiter) Take(name string, perHour int) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.runs[name][:0]
+	for _, t := range l.runs[name] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	l.runs[name] = recent
+	if len(recent) >= perHour {
+		return recent[0].Add(time.Hour).Sub(now), false
+	}
+	l.runs[name] = append(recent, now)
+	return 0, true
+}
+
+// enforceQuota checks the size of req against the token's quota (403).
+// Closed-loop duration runs cannot be sized up front, so they are capped at
+// MaxRequests instead.
+func enforceQuota(req *ProbeRequest, token *APIToken) error {
+	if token == nil || token.Quota == nil {
+		return nil
+	}
+	q := token.Quota
+
+	if q.MaxConcurrency > 0 {
+		workers := req.Concurrency
+		if len(req.Stages) > 0 && req.StageMode == StageModeConcurrency {
+			workers = int(math.Ceil(stagesMaxTarget(req.Stages)))
+		}
+		if workers > q.MaxConcurrency {
+			return &apiError{Code: http.StatusForbidden, Msg: fmt.Sprintf("Concurrency %d exceeds the quota of %d for this token", workers, q.MaxConcurrency)}
+		}
+	}
+
+	if q.MaxRequests > 0 {
+		if planned, ok := plannedRequests(req); ok && planned > q.MaxRequests {
+			return &apiError{Code: http.StatusForbidden, Msg: fmt.Sprintf("Run of %d requests exceeds the quota of %d for this token", planned, q.MaxRequests)}
+		}
+		req.MaxRequests = q.MaxRequests
+	}
+	return nil
+}
+
+// takeRun counts a run against the token's hourly budget; 429 once spent.
+// Called once the run is validated, so refused runs do not use up the budget.
+func takeRun(token *APIToken) error {
+	if token == nil || token.Quota == nil || token.Quota.RunsPerHour <= 0 {
+		return nil
+	}
+	perHour := token.Quota.RunsPerHour
+	if wait, ok := runs.Take(token.Name, perHour); !ok {
+		return &apiError{Code: http.StatusTooManyRequests, Msg: fmt.Sprintf("Hourly quota of %d runs used up for this token", perHour), RetryAfter: wait}
+	}
+	return nil
+}
+
+// plannedRequests is how many requests req will send, when known up front.
+func plannedRequests(req *ProbeRequest) (int, bool) {
+	switch {
+	case len(req.Stages) > 0 && req.StageMode != StageModeConcurrency:
+		// Area under the linear ramps
+		var total, from float64
+		for _, st := range req.Stages {
+			total += (from + st.Target) / 2 * st.Duration
+			from = st.Target
+		}
+		return int(math.Ceil(total)), true
+	case len(req.Stages) > 0:
+		return 0, false
+	case req.Duration > 0 && req.Rate > 0:
+		return int(math.Ceil(req.Rate * req.Duration)), true
+	case req.Duration > 0:
+		return 0, false
+	}
+	return req.Count, true
+}
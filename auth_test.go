@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPlannedRequests(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   ProbeRequest
+		want  int
+		known bool
+	}{
+		{"count", ProbeRequest{Count: 100}, 100, true},
+		{"rate and duration", ProbeRequest{Count: 100, Rate: 10, Duration: 30}, 300, true},
+		{"closed-loop duration", ProbeRequest{Count: 100, Duration: 30}, 0, false},
+		{"rate stages", ProbeRequest{Stages: []Stage{{Duration: 10, Target: 10}, {Duration: 10, Target: 10}, {Duration: 10, Target: 0}}}, 200, true},
+		{"explicit rate mode", ProbeRequest{StageMode: StageModeRate, Stages: []Stage{{Duration: 3, Target: 1}}}, 2, true},
+		{"concurrency stages", ProbeRequest{StageMode: StageModeConcurrency, Stages: []Stage{{Duration: 10, Target: 10}}}, 0, false},
+	}
+	for _, tt := range tests {
+		got, known := plannedRequests(&tt.req)
+		if got != tt.want || known != tt.known {
+			t.Errorf("%s: plannedRequests() = %d, %v, want %d, %v", tt.name, got, known, tt.want, tt.known)
+		}
+	}
+}
+
+func TestEnforceQuota(t *testing.T) {
+	token := &APIToken{Name: "ci", Quota: &Quota{MaxConcurrency: 50, MaxRequests: 1000}}
+	tests := []struct {
+		name  string
+		token *APIToken
+		req   ProbeRequest
+		ok    bool
+		cap   int // MaxRequests set on req
+	}{
+		{"open api", nil, ProbeRequest{Concurrency: 1000, Count: 1 << 20}, true, 0},
+		{"no quota", &APIToken{Name: "admin"}, ProbeRequest{Concurrency: 1000, Count: 1 << 20}, true, 0},
+		{"within quota", token, ProbeRequest{Concurrency: 50, Count: 1000}, true, 1000},
+		{"too many workers", token, ProbeRequest{Concurrency: 51, Count: 10}, false, 0},
+		{"concurrency stages", token, ProbeRequest{Concurrency: 10, Count: 10, StageMode: StageModeConcurrency,
+			Stages: []Stage{{Duration: 10, Target: 20}, {Duration: 10, Target: 60}}}, false, 0},
+		{"too many requests", token, ProbeRequest{Concurrency: 10, Count: 1001}, false, 0},
+		{"rate run too long", token, ProbeRequest{Concurrency: 10, Rate: 100, Duration: 11}, false, 0},
+		{"rate stages too big", token, ProbeRequest{Concurrency: 10, Stages: []Stage{{Duration: 60, Target: 100}}}, false, 0},
+		{"closed-loop duration is capped", token, ProbeRequest{Concurrency: 10, Count: 100, Duration: 3600}, true, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := enforceQuota(&tt.req, tt.token)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("enforceQuota() = %v, want nil", err)
+				}
+				if tt.req.MaxRequests != tt.cap {
+					t.Errorf("MaxRequests = %d, want %d", tt.req.MaxRequests, tt.cap)
+				}
+				return
+			}
+			var apiErr *apiError
+			if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+				t.Errorf("enforceQuota() = %v, want a 403", err)
+			}
+		})
+	}
+}
+
+func TestRunLimiter(t *testing.T) {
+	l := &runLimiter{runs: make(map[string][]time.Time)}
+	for i := 0; i < 2; i++ {
+		if _, ok := l.Take("ci", 2); !ok {
+			t.Fatalf("run %d refused", i+1)
+		}
+	}
+	wait, ok := l.Take("ci", 2)
+	if ok {
+		t.Fatal("third run allowed with a budget of 2")
+	}
+	if wait <= 0 || wait > time.Hour {
+		t.Errorf("wait = %v, want within the hour", wait)
+	}
+	if _, ok := l.Take("other", 2); !ok {
+		t.Error("budget is shared across tokens")
+	}
+
+	// Runs older than an hour no longer count
+	l.runs["ci"][0] = time.Now().Add(-2 * time.Hour)
+	if _, ok := l.Take("ci", 2); !ok {
+		t.Error("expired run still counted")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Checks are response assertions evaluated by the Worker. Any failed check
+// makes the request count as an error.
+type Checks struct {
+	Status        string                 `json:"status,omitempty"`         // StatusSet spec, e.g. "200,204"
+	BodyContains  string                 `json:"body_contains,omitempty"`  // substring
+	BodyRegex     string                 `json:"body_regex,omitempty"`     // RE2 pattern
+	JSONEquals    map[string]interface{} `json:"json_equals,omitempty"`    // "data.items[0].id" -> expected value
+	HeaderPresent []string               `json:"header_present,omitempty"` // header names
+	MaxBodyBytes  int64                  `json:"max_body_bytes,omitempty"`
+	MaxLatencyMs  float64                `json:"max_latency_ms,omitempty"`
+}
+
+// Empty reports whether no check is configured.
+func (c *Checks) Empty() bool {
+	return c == nil || (c.Status == "" && c.BodyContains == "" && c.BodyRegex == "" &&
+		len(c.JSONEquals) == 0 && len(c.HeaderPresent) == 0 && c.MaxBodyBytes == 0 && c.MaxLatencyMs == 0)
+}
+
+// Bodies are read into memory up to this size for content checks; the rest
+// is only counted. Every worker holds one such buffer, so with the web
+// API's 1000 workers this bounds body memory to 256 MiB.
+const checkBodyLimit = 256 << 10
+
+// checkSampleLimit is how many failing responses are kept per check, with
+// at most checkSampleBody bytes of body each.
+const (
+	checkSampleLimit = 3
+	checkSampleBody  = 256
+)
+
+// CheckResult is the outcome of one check on one response.
+type CheckResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// CheckStats aggregates a check across a run.
+type CheckStats struct {
+	Name    string        `json:"name"`
+	Passes  int           `json:"passes"`
+	Fails   int           `json:"fails"`
+	Samples []CheckSample `json:"samples,omitempty"`
+}
+
+// CheckSample is a failing response kept for inspection.
+type CheckSample struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Detail     string `json:"detail"`
+	Body       string `json:"body,omitempty"`
+}
+
+type check struct {
+	name     string
+	needBody bool
+	eval     func(resp *http.Response, body []byte, size int64, latency time.Duration) (bool, string)
+}
+
+// checkSet is the compiled form of Checks, shared read-only by the workers.
+type checkSet struct {
+	checks   []check
+	needBody bool
+}
+
+// compileChecks validates c; prefix (the scenario request name) is prepended
+// to the check names so they stay unique across a scenario.
+func compileChecks(c *Checks, prefix string) (*checkSet, error) {
+	if c.Empty() {
+		return nil, nil
+	}
+
+	name := func(n string) string {
+		if prefix == "" {
+			return n
+		}
+		return prefix + ": " + n
+	}
+
+	set := &checkSet{}
+	add := func(ch check) {
+		set.checks = append(set.checks, ch)
+		set.needBody = set.needBody || ch.needBody
+	}
+
+	if c.Status != "" {
+		status, err := ParseStatusSet(c.Status)
+		if err != nil {
+			return nil, fmt.Errorf("check status: %v", err)
+		}
+		add(check{name: name("status " + c.Status), eval: func(resp *http.Response, _ []byte, _ int64, _ time.Duration) (bool, string) {
+			return status.Contains(resp.StatusCode), fmt.Sprintf("got status %d", resp.StatusCode)
+		}})
+	}
+
+	if c.BodyContains != "" {
+		want := []byte(c.BodyContains)
+		add(check{name: name("body contains " + strconv.Quote(c.BodyContains)), needBody: true, eval: func(_ *http.Response, body []byte, _ int64, _ time.Duration) (bool, string) {
+			return bytes.Contains(body, want), "substring not found"
+		}})
+	}
+
+	if c.BodyRegex != "" {
+		re, err := regexp.Compile(c.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("check body_regex: %v", err)
+		}
+		add(check{name: name("body matches /" + c.BodyRegex + "/"), needBody: true, eval: func(_ *http.Response, body []byte, _ int64, _ time.Duration) (bool, string) {
+			return re.Match(body), "no match"
+		}})
+	}
+
+	paths := make([]string, 0, len(c.JSONEquals))
+	for path := range c.JSONEquals {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		path, want := path, c.JSONEquals[path]
+		keys, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		// Round-trip so numbers compare as float64 like decoded bodies
+		raw, _ := json.Marshal(want)
+		json.Unmarshal(raw, &want)
+		add(check{name: name("json " + path + " == " + string(raw)), needBody: true, eval: func(_ *http.Response, body []byte, _ int64, _ time.Duration) (bool, string) {
+			var doc interface{}
+			if err := json.Unmarshal(body, &doc); err != nil {
+				return false, "body is not JSON"
+			}
+			got, ok := lookupJSONPath(doc, keys)
+			if !ok {
+				return false, "path not found"
+			}
+			gotJSON, _ := json.Marshal(got)
+			return reflect.DeepEqual(got, want), "got " + string(gotJSON)
+		}})
+	}
+
+	for _, h := range c.HeaderPresent {
+		h := http.CanonicalHeaderKey(strings.TrimSpace(h))
+		add(check{name: name("header " + h + " present"), eval: func(resp *http.Response, _ []byte, _ int64, _ time.Duration) (bool, string) {
+			return len(resp.Header.Values(h)) > 0, "header missing"
+		}})
+	}
+
+	if c.MaxBodyBytes > 0 {
+		max := c.MaxBodyBytes
+		add(check{name: name(fmt.Sprintf("body <= %d bytes", max)), eval: func(_ *http.Response, _ []byte, size int64, _ time.Duration) (bool, string) {
+			return size <= max, fmt.Sprintf("body is %d bytes", size)
+		}})
+	}
+
+	if c.MaxLatencyMs > 0 {
+		max := time.Duration(c.MaxLatencyMs * float64(time.Millisecond))
+		add(check{name: name(fmt.Sprintf("latency <= %v", max)), eval: func(_ *http.Response, _ []byte, _ int64, latency time.Duration) (bool, string) {
+			return latency <= max, fmt.Sprintf("took %v", latency)
+		}})
+	}
+
+	return set, nil
+}
+
+// Names lists the check names in evaluation order.
+func (s *checkSet) Names() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, len(s.checks))
+	for i, ch := range s.checks {
+		names[i] = ch.name
+	}
+	return names
+}
+
+// Evaluate consumes resp.Body (into buf when needed) and runs every check.
+// The body is returned so failing responses can be sampled, with its full
+// size.
+func (s *checkSet) Evaluate(resp *http.Response, buf *bytes.Buffer, drain []byte, latency time.Duration) ([]CheckResult, []byte, int64) {
+	var body []byte
+	var size int64
+	buf.Reset()
+	if s.needBody {
+		n, _ := io.Copy(buf, io.LimitReader(resp.Body, checkBodyLimit))
+		rest, _ := io.CopyBuffer(io.Discard, resp.Body, drain)
+		body, size = buf.Bytes(), n+rest
+	} else {
+		size, _ = io.CopyBuffer(io.Discard, resp.Body, drain)
+	}
+
+	results := make([]CheckResult, len(s.checks))
+	for i, ch := range s.checks {
+		ok, detail := ch.eval(resp, body, size, latency)
+		results[i] = CheckResult{Name: ch.name, OK: ok}
+		if !ok {
+			results[i].Detail = detail
+		}
+	}
+	return results, body, size
+}
+
+// parseJSONPath splits "a.b[0].c" (or "a.b.0.c") into keys.
+func parseJSONPath(path string) ([]string, error) {
+	var keys []string
+	for _, part := range strings.Split(strings.ReplaceAll(path, "[", ".["), ".") {
+		if part == "" {
+			continue
+		}
+		if strings.HasPrefix(part, "[") {
+			if !strings.HasSuffix(part, "]") {
+				return nil, fmt.Errorf("invalid json path %q", path)
+			}
+			part = part[1 : len(part)-1]
+		}
+		keys = append(keys, part)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty json path")
+	}
+	return keys, nil
+}
+
+func lookupJSONPath(doc interface{}, keys []string) (interface{}, bool) {
+	cur := doc
+	for _, key := range keys {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// ParseJSONCheck parses a CLI "path=value" assertion. The value is decoded
+// as JSON when possible and used as a plain string otherwise.
+func ParseJSONCheck(arg string) (string, interface{}, error) {
+	path, raw, ok := strings.Cut(arg, "=")
+	if !ok || strings.TrimSpace(path) == "" {
+		return "", nil, fmt.Errorf("invalid json check %q (expected path=value)", arg)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		v = raw
+	}
+	return strings.TrimSpace(path), v, nil
+}
+
+// checkCollector aggregates CheckResults in definition order.
+type checkCollector struct {
+	order []string
+	stats map[string]*CheckStats
+}
+
+func newCheckCollector(names []string) *checkCollector {
+	c := &checkCollector{stats: make(map[string]*CheckStats, len(names))}
+	for _, name := range names {
+		c.order = append(c.order, name)
+		c.stats[name] = &CheckStats{Name: name}
+	}
+	return c
+}
+
+func (c *checkCollector) Add(res Result) {
+	for _, cr := range res.Checks {
+		st, ok := c.stats[cr.Name]
+		if !ok {
+			st = &CheckStats{Name: cr.Name}
+			c.stats[cr.Name] = st
+			c.order = append(c.order, cr.Name)
+		}
+		if cr.OK {
+			st.Passes++
+			continue
+		}
+		st.Fails++
+		if len(st.Samples) < checkSampleLimit {
+			st.Samples = append(st.Samples, CheckSample{
+				URL:        res.URL,
+				StatusCode: res.StatusCode,
+				Detail:     cr.Detail,
+				Body:       res.BodySample,
+			})
+		}
+	}
+}
+
+func (c *checkCollector) Stats() []CheckStats {
+	if len(c.order) == 0 {
+		return nil
+	}
+	out := make([]CheckStats, len(c.order))
+	for i, name := range c.order {
+		st := *c.stats[name]
+		st.Samples = append([]CheckSample(nil), st.Samples...)
+		out[i] = st
+	}
+	return out
+}
@@ -1,43 +1,172 @@
 package main
 
 import (
-	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
-// NewClient returns an ULTRA-OPTIMIZED http.Client for maximum throughput.
-func NewClient(timeout time.Duration) *http.Client {
+// Protocols for ProbeRequest.Protocol
+const (
+	ProtocolAuto  = "auto"  // HTTP/2 when the server offers it over TLS, else HTTP/1.1
+	ProtocolHTTP1 = "http1" // HTTP/1.1 only
+	ProtocolHTTP2 = "http2" // HTTP/2 over TLS only
+	ProtocolH2C   = "h2c"   // cleartext HTTP/2 with prior knowledge
+)
+
+// protocols maps ProbeRequest.Protocol to the transport's protocol set.
+func protocols(name string) (*http.Protocols, error) {
+	p := new(http.Protocols)
+	switch name {
+	case "", ProtocolAuto:
+		p.SetHTTP1(true)
+		p.SetHTTP2(true)
+	case ProtocolHTTP1:
+		p.SetHTTP1(true)
+	case ProtocolHTTP2:
+		p.SetHTTP2(true)
+	case ProtocolH2C:
+		p.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q (expected auto, http1, http2 or h2c)", name)
+	}
+	return p, nil
+}
+
+// seconds converts a ProbeRequest duration, using def when unset.
+func seconds(s float64, def time.Duration) time.Duration {
+	if s <= 0 {
+		return def
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// checkProtocolScheme refuses URLs the protocol cannot serve, which the
+// transport would otherwise quietly send over HTTP/1.1.
+func checkProtocolScheme(protocol, url string) error {
+	switch {
+	case protocol == ProtocolHTTP2 && !strings.HasPrefix(url, "https://"):
+		return fmt.Errorf("protocol http2 needs an https URL (use h2c for cleartext): %s", url)
+	case protocol == ProtocolH2C && !strings.HasPrefix(url, "http://"):
+		return fmt.Errorf("protocol h2c needs an http URL (use http2 over TLS): %s", url)
+	}
+	return nil
+}
+
+// NewClient returns an ULTRA-OPTIMIZED http.Client for maximum throughput,
+// configured from req's timeout, protocol, TLS, DNS and connection options
+// and address guard.
+func NewClient(req ProbeRequest) (*http.Client, error) {
+	tlsConfig, err := req.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	protos, err := protocols(req.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	idleConns := 500 // Many connections per target
+	if req.IdleConns > 0 {
+		idleConns = req.IdleConns
+	}
+
 	// Custom dialer with aggressive timeouts
 	dialer := &net.Dialer{
-		Timeout:   3 * time.Second,  // Fast connection timeout
-		KeepAlive: 60 * time.Second, // Keep connections alive
+		Timeout:   seconds(req.DialTimeout, 3*time.Second), // Fast connection timeout
+		KeepAlive: 60 * time.Second,                        // Keep connections alive
+	}
+
+	dial, err := dialContext(dialer, req)
+	if err != nil {
+		return nil, err
 	}
 
 	transport := &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           dialer.DialContext,
-		ForceAttemptHTTP2:     true, // Use HTTP/2 for speed
-		MaxIdleConns:          1000, // HUGE connection pool
-		MaxIdleConnsPerHost:   500,  // Many connections per target
-		MaxConnsPerHost:       0,    // Unlimited
+		DialContext:           dial,
+		Protocols:             protos,
+		DisableKeepAlives:     req.DisableKeepAlive,
+		MaxIdleConns:          max(1000, idleConns), // HUGE connection pool
+		MaxIdleConnsPerHost:   idleConns,
+		MaxConnsPerHost:       req.MaxConnsPerHost, // 0 = Unlimited
 		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   3 * time.Second, // Fast TLS
+		TLSHandshakeTimeout:   seconds(req.TLSHandshakeTimeout, 3*time.Second), // Fast TLS
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableCompression:    true, // Skip decompression overhead
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // Skip cert validation
-			MinVersion:         tls.VersionTLS12,
-		},
+		TLSClientConfig:       tlsConfig,
+	}
+
+	if req.Guard != nil {
+		dialer.Control = req.Guard.Control
+		// A proxy would resolve the target out of the guard's sight
+		transport.Proxy = nil
+	}
+
+	checkRedirect, err := redirectPolicy(req)
+	if err != nil {
+		return nil, err
 	}
 
 	return &http.Client{
-		Timeout:   timeout,
-		Transport: transport,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		Timeout:       time.Duration(req.Timeout) * time.Second,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}, nil
+}
+
+// Redirect policies for ProbeRequest.Redirect
+const (
+	RedirectNone     = "none"      // measure the redirect response itself
+	RedirectFollow   = "follow"    // follow up to MaxRedirects
+	RedirectSameHost = "same-host" // follow, but stop at a redirect to another host
+)
+
+const defaultMaxRedirects = 10
+
+var (
+	errTooManyRedirects = errors.New("too many redirects")
+	errRedirectRefused  = errors.New("redirect outside the target allowlist")
+)
+
+// redirectPolicy builds the CheckRedirect for req. Followed redirects are
+// counted on the request's phaseTrace.
+func redirectPolicy(req ProbeRequest) (func(*http.Request, []*http.Request) error, error) {
+	switch req.Redirect {
+	case "", RedirectNone:
+		return func(*http.Request, []*http.Request) error {
 			// Don't follow redirects - saves time
 			return http.ErrUseLastResponse
-		},
+		}, nil
+	case RedirectFollow, RedirectSameHost:
+	default:
+		return nil, fmt.Errorf("unknown redirect policy %q (expected none, follow or same-host)", req.Redirect)
+	}
+
+	limit := req.MaxRedirects
+	if limit <= 0 {
+		limit = defaultMaxRedirects
 	}
+	return func(next *http.Request, via []*http.Request) error {
+		trace, _ := next.Context().Value(phaseTraceKey{}).(*phaseTrace)
+		if trace != nil {
+			trace.mark(&trace.firstHop, true)
+		}
+		if req.Redirect == RedirectSameHost && !strings.EqualFold(next.URL.Host, via[0].URL.Host) {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > limit {
+			return fmt.Errorf("%w (%d)", errTooManyRedirects, limit)
+		}
+		if req.Policy != nil && !req.Policy.Allowed(next.URL.Hostname()) {
+			return fmt.Errorf("%w: %s", errRedirectRefused, next.URL.Host)
+		}
+		if trace != nil {
+			trace.redirected()
+		}
+		return nil
+	}, nil
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProtocols(t *testing.T) {
+	tests := []struct {
+		name                    string
+		http1, http2, unencrypt bool
+	}{
+		{"", true, true, false},
+		{ProtocolAuto, true, true, false},
+		{ProtocolHTTP1, true, false, false},
+		{ProtocolHTTP2, false, true, false},
+		{ProtocolH2C, false, false, true},
+	}
+	for _, tt := range tests {
+		p, err := protocols(tt.name)
+		if err != nil {
+			t.Fatalf("protocols(%q): %v", tt.name, err)
+		}
+		if p.HTTP1() != tt.http1 || p.HTTP2() != tt.http2 || p.UnencryptedHTTP2() != tt.unencrypt {
+			t.Errorf("protocols(%q) = %v", tt.name, p)
+		}
+	}
+	if _, err := protocols("spdy"); err == nil {
+		t.Error("protocols accepted an unknown protocol")
+	}
+}
+
+func TestCheckProtocolScheme(t *testing.T) {
+	tests := []struct {
+		protocol, url string
+		ok            bool
+	}{
+		{"", "http://example.com", true},
+		{ProtocolAuto, "https://example.com", true},
+		{ProtocolHTTP1, "http://example.com", true},
+		{ProtocolHTTP2, "https://example.com", true},
+		{ProtocolHTTP2, "http://example.com", false},
+		{ProtocolH2C, "http://example.com", true},
+		{ProtocolH2C, "https://example.com", false},
+	}
+	for _, tt := range tests {
+		if err := checkProtocolScheme(tt.protocol, tt.url); (err == nil) != tt.ok {
+			t.Errorf("checkProtocolScheme(%q, %q) = %v, want ok=%v", tt.protocol, tt.url, err, tt.ok)
+		}
+	}
+}
+
+// probeOnce sends a single request for req through a Worker.
+func probeOnce(t *testing.T, req ProbeRequest) Result {
+	t.Helper()
+	client, err := NewClient(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.CloseIdleConnections()
+	spec, err := NewRequestSpec(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := make(chan Target, 1)
+	results := make(chan Result, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go Worker(context.Background(), 0, targets, results, client, &wg)
+	targets <- Target{Spec: spec}
+	close(targets)
+	wg.Wait()
+	return <-results
+}
+
+func TestClientProtocolRoundTrip(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	plain := httptest.NewServer(ok)
+	defer plain.Close()
+
+	h2c := httptest.NewUnstartedServer(ok)
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetHTTP1(true)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+
+	tlsSrv := httptest.NewUnstartedServer(ok)
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	insecure := &TLSOptions{Insecure: true}
+	tests := []struct {
+		name     string
+		protocol string
+		url      string
+		tls      *TLSOptions
+		want     string
+	}{
+		{"http1 cleartext", ProtocolHTTP1, plain.URL, nil, "HTTP/1.1"},
+		{"http1 tls", ProtocolHTTP1, tlsSrv.URL, insecure, "HTTP/1.1"},
+		{"auto cleartext", ProtocolAuto, plain.URL, nil, "HTTP/1.1"},
+		{"auto tls", ProtocolAuto, tlsSrv.URL, insecure, "HTTP/2.0"},
+		{"http2", ProtocolHTTP2, tlsSrv.URL, insecure, "HTTP/2.0"},
+		{"h2c", ProtocolH2C, h2c.URL, nil, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := probeOnce(t, ProbeRequest{URL: tt.url, Timeout: 5, Protocol: tt.protocol, TLS: tt.tls})
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if res.Proto != tt.want {
+				t.Errorf("Proto = %q, want %q", res.Proto, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionHeader(t *testing.T) {
+	got := make(chan []string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Values("Connection")
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		disable bool
+		want    string
+	}{
+		{false, ""},
+		{true, "close"},
+	} {
+		res := probeOnce(t, ProbeRequest{URL: srv.URL, Timeout: 5, DisableKeepAlive: tt.disable})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if values := <-got; strings.Join(values, ",") != tt.want {
+			t.Errorf("DisableKeepAlive=%v: Connection = %q, want %q", tt.disable, values, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// reportCommand implements "mechanic report": it recomputes the summary of
+// a run from its -results log. Returns the process exit code.
+func reportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	from := fs.Duration("from", 0, "Only count requests started at least this long after the first one (e.g. 30s)")
+	to := fs.Duration("to", 0, "Only count requests started before this offset from the first one")
+	url := fs.String("url", "", "Only count requests whose URL or scenario name contains this text")
+	successStatus := fs.String("success", DefaultSuccessStatus, "Status codes counted as success")
+	bucket := fs.Duration("bucket", defaultBucket, "Timeline bucket width")
+	var thresholds listFlags
+	fs.Var(&thresholds, "threshold", "Pass/fail gate evaluated on the recomputed stats (repeatable)")
+	formatArg := fs.String("format", "", "Report format: text, json, csv or markdown")
+	outPath := fs.String("o", "", "Write the report to this file instead of stdout")
+	htmlPath := fs.String("html", "", "Also write a self-contained HTML report to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mechanic report [flags] <results.ndjson>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	path := fs.Arg(0)
+
+	success, err := ParseStatusSet(*successStatus)
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+	parsed, err := ParseThresholds(thresholds)
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+	format, out, err := openReport(*formatArg, *outPath)
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+
+	if *bucket < minBucket {
+		fmt.Printf("[!] bucket must be at least %v\n", minBucket)
+		return 1
+	}
+
+	filter := ResultFilter{From: *from, To: *to, URL: *url}
+	stats, started, err := ReplayResults(path, filter, success, *bucket)
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+	stats.Thresholds, stats.ThresholdsPassed = EvaluateThresholds(parsed, stats)
+
+	target := path
+	var where []string
+	if *url != "" {
+		where = append(where, "url ~ "+*url)
+	}
+	if *from > 0 || *to > 0 {
+		window := fmt.Sprintf("%v..", *from)
+		if *to > 0 {
+			window += (*to).String()
+		}
+		where = append(where, "window "+window)
+	}
+	if len(where) > 0 {
+		target += " (" + strings.Join(where, ", ") + ")"
+	}
+
+	config := ProbeRequest{URL: stats.TargetURL, SuccessStatus: *successStatus, Thresholds: thresholds, Bucket: bucket.Seconds()}
+	report := NewReport(target, config, stats, started)
+	if err := emitReport(report, format, out); err != nil {
+		fmt.Fprintf(os.Stderr, "[!] Writing report: %v\n", err)
+		return 1
+	}
+	if *htmlPath != "" {
+		if err := writeHTMLReport(report, *htmlPath); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] Writing HTML report: %v\n", err)
+			return 1
+		}
+	}
+	if len(stats.Thresholds) > 0 && !stats.ThresholdsPassed {
+		fmt.Fprintf(os.Stderr, "\n[!] Thresholds failed\n")
+		return exitThresholdsFailed
+	}
+	return 0
+}
+
+// compareCommand implements "mechanic compare": it diffs two JSON reports
+// and exits with exitRegression when the head run is meaningfully worse.
+func compareCommand(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	latency := fs.String("latency", "10%", "Allowed relative increase of mean latency and each percentile")
+	throughput := fs.String("throughput", "10%", "Allowed relative drop in throughput")
+	errRate := fs.String("error-rate", "1%", "Allowed absolute increase of the error rate")
+	minLatency := fs.Duration("min-latency", DefaultTolerances.MinLatency, "Ignore latency increases smaller than this")
+	formatArg := fs.String("format", FormatText, "Output format: text, json or markdown")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mechanic compare [flags] <base.json> <head.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	tol := Tolerances{MinLatency: *minLatency}
+	for _, t := range []struct {
+		flag, value string
+		dst         *float64
+	}{
+		{"latency", *latency, &tol.Latency},
+		{"throughput", *throughput, &tol.Throughput},
+		{"error-rate", *errRate, &tol.ErrorRate},
+	} {
+		v, err := parseRate(t.value)
+		if err != nil || v < 0 {
+			fmt.Printf("[!] invalid -%s %q (expected e.g. 10%% or 0.1)\n", t.flag, t.value)
+			return 1
+		}
+		*t.dst = v
+	}
+	format, err := ReportFormat(*formatArg, "")
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+
+	base, err := LoadReport(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+	head, err := LoadReport(fs.Arg(1))
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+
+	cmp := CompareReports(base, head, tol)
+	if err := cmp.Write(os.Stdout, format); err != nil {
+		fmt.Printf("[!] %v\n", err)
+		return 1
+	}
+	if cmp.Regressed {
+		fmt.Fprintf(os.Stderr, "\n[!] Regression detected\n")
+		return exitRegression
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Tolerances are how much worse the new run may be before a metric counts
+// as a regression. Latency and throughput are relative changes, ErrorRate
+// is an absolute increase of the error fraction.
+type Tolerances struct {
+	Latency    float64 `json:"latency"`
+	Throughput float64 `json:"throughput"`
+	ErrorRate  float64 `json:"error_rate"`
+
+	// Latency increases below MinLatency are ignored as noise, and so are
+	// percentiles backed by fewer than minTailSamples requests above them.
+	MinLatency time.Duration `json:"min_latency"`
+}
+
+var DefaultTolerances = Tolerances{
+	Latency:    0.10,
+	Throughput: 0.10,
+	ErrorRate:  0.01,
+	MinLatency: time.Millisecond,
+}
+
+// minTailSamples is the fewest requests that must lie above a percentile in
+// both runs for a change in it to be trusted.
+const minTailSamples = 10
+
+// errorRateZ is the z-score a rise in error rate must exceed to count, i.e.
+// 95% confidence in a two-proportion test.
+const errorRateZ = 1.96
+
+// Delta is one metric compared between a base and a head run.
+type Delta struct {
+	Metric     string  `json:"metric"`
+	Unit       string  `json:"unit"` // "ms", "req/s" or "%"
+	Base       float64 `json:"base"`
+	Head       float64 `json:"head"`
+	Change     float64 `json:"change"` // relative; absolute points for error_rate
+	Regression bool    `json:"regression"`
+	Note       string  `json:"note,omitempty"` // why a change was not flagged
+}
+
+// Comparison is the outcome of CompareReports.
+type Comparison struct {
+	Base       string     `json:"base"`
+	Head       string     `json:"head"`
+	Tolerances Tolerances `json:"tolerances"`
+	Deltas     []Delta    `json:"deltas"`
+	Regressed  bool       `json:"regressed"`
+}
+
+// relChange is 0 when there is no baseline to compare against.
+func relChange(base, head float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (head - base) / base
+}
+
+// CompareReports diffs head against base.
+func CompareReports(base, head *Report, tol Tolerances) Comparison {
+	b, h := base.Stats, head.Stats
+	cmp := Comparison{Base: base.Target, Head: head.Target, Tolerances: tol}
+	add := func(d Delta) {
+		cmp.Deltas = append(cmp.Deltas, d)
+		cmp.Regressed = cmp.Regressed || d.Regression
+	}
+
+	// Throughput: lower is worse
+	tp := Delta{Metric: "throughput", Unit: "req/s", Base: b.Throughput, Head: h.Throughput, Change: relChange(b.Throughput, h.Throughput)}
+	tp.Regression = -tp.Change > tol.Throughput
+	add(tp)
+
+	// Error rate: absolute increase, and only when it is unlikely to be chance
+	be, he := errorRate(b), errorRate(h)
+	er := Delta{Metric: "error_rate", Unit: "%", Base: be * 100, Head: he * 100, Change: (he - be) * 100}
+	if he-be > tol.ErrorRate {
+		if z := errorRateZScore(b, h); z > errorRateZ {
+			er.Regression = true
+		} else {
+			er.Note = fmt.Sprintf("not significant (z=%.2f)", z)
+		}
+	}
+	add(er)
+
+	samples := b.Latency.Count
+	if h.Latency.Count < samples {
+		samples = h.Latency.Count
+	}
+	for _, p := range []struct {
+		name     string
+		q        float64
+		base, hd time.Duration
+	}{
+		{"mean", 0, b.Latency.Mean, h.Latency.Mean},
+		{"p50", 0.50, b.Latency.P50, h.Latency.P50},
+		{"p90", 0.90, b.Latency.P90, h.Latency.P90},
+		{"p95", 0.95, b.Latency.P95, h.Latency.P95},
+		{"p99", 0.99, b.Latency.P99, h.Latency.P99},
+		{"p99.9", 0.999, b.Latency.P999, h.Latency.P999},
+	} {
+		d := Delta{Metric: p.name, Unit: "ms", Base: durationMs(p.base), Head: durationMs(p.hd), Change: relChange(float64(p.base), float64(p.hd))}
+		if d.Change > tol.Latency {
+			switch {
+			case p.hd-p.base < tol.MinLatency:
+				d.Note = fmt.Sprintf("below %v", tol.MinLatency)
+			case (1-p.q)*float64(samples) < minTailSamples:
+				d.Note = "too few samples"
+			default:
+				d.Regression = true
+			}
+		}
+		add(d)
+	}
+	return cmp
+}
+
+// errorRateZScore is the two-proportion z-score of head's error rate
+// against base's.
+func errorRateZScore(b, h ProbeStats) float64 {
+	n1, n2 := float64(b.TotalRequest), float64(h.TotalRequest)
+	if n1 == 0 || n2 == 0 {
+		return 0
+	}
+	p1, p2 := float64(b.ErrorCount)/n1, float64(h.ErrorCount)/n2
+	pooled := float64(b.ErrorCount+h.ErrorCount) / (n1 + n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/n1 + 1/n2))
+	if se == 0 {
+		return 0
+	}
+	return (p2 - p1) / se
+}
+
+func (c Comparison) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(c)
+	case FormatMarkdown:
+		fmt.Fprintf(w, "# Comparison\n\nBase: %s  \nHead: %s\n\n", c.Base, c.Head)
+		fmt.Fprintf(w, "| Metric | Base | Head | Change | Result |\n|---|---|---|---|---|\n")
+		for _, d := range c.Deltas {
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s |\n", d.Metric, d.value(d.Base), d.value(d.Head), d.change(), d.result())
+		}
+		return nil
+	case FormatText:
+		fmt.Fprintf(w, "\n--- Comparison ---\nBase: %s\nHead: %s\n\n", c.Base, c.Head)
+		fmt.Fprintf(w, "  %-11s %12s %12s %10s  %s\n", "METRIC", "BASE", "HEAD", "CHANGE", "RESULT")
+		for _, d := range c.Deltas {
+			fmt.Fprintf(w, "  %-11s %12s %12s %10s  %s\n", d.Metric, d.value(d.Base), d.value(d.Head), d.change(), d.result())
+		}
+		return nil
+	}
+	return fmt.Errorf("format %s is not supported for comparisons", format)
+}
+
+func (d Delta) value(v float64) string {
+	return fmt.Sprintf("%.2f %s", v, d.Unit)
+}
+
+func (d Delta) change() string {
+	if d.Unit == "%" {
+		return fmt.Sprintf("%+.2fpp", d.Change)
+	}
+	return fmt.Sprintf("%+.1f%%", d.Change*100)
+}
+
+func (d Delta) result() string {
+	if d.Regression {
+		return "REGRESSION"
+	}
+	if d.Note != "" {
+		return "ok (" + d.Note + ")"
+	}
+	return "ok"
+}
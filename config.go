@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ServerConfig is the web mode configuration, read from -config (JSON) and
+// overridden by environment variables:
+//
+//	MECHANIC_TOKENS        comma separated "name=token" (or bare tokens),
+//	                       each given DefaultQuota
+//	MECHANIC_CORS_ORIGINS  comma separated origins, "*" for any
+//	MECHANIC_TARGETS       comma separated target allowlist
+//	MECHANIC_ALLOW_INTERNAL comma separated internal CIDRs probes may reach
+type ServerConfig struct {
+	// Origins allowed to call the API from a browser. Empty means same
+	// origin only.
+	CORSOrigins []string `json:"cors_origins"`
+
+	// With no tokens the API is open to anyone.
+	Tokens       []APIToken `json:"tokens"`
+	DefaultQuota Quota      `json:"default_quota"` // for tokens without a quota of their own
+
+	Targets TargetPolicy `json:"targets"`
+
+	// Loopback, private, link-local and metadata addresses are refused
+	// unless listed here (e.g. "10.20.0.0/16" for staging).
+	AllowInternal []string `json:"allow_internal"`
+	guard         *AddrGuard
+}
+
+// serverConfig is the config of the running web server.
+var serverConfig = &ServerConfig{}
+
+// LoadServerConfig reads path (if set) and applies the environment.
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	cfg := &ServerConfig{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file: %v", err)
+		}
+	}
+
+	if env := os.Getenv("MECHANIC_CORS_ORIGINS"); env != "" {
+		cfg.CORSOrigins = splitList(env)
+	}
+	if env := os.Getenv("MECHANIC_TARGETS"); env != "" {
+		cfg.Targets.Allow = splitList(env)
+	}
+	if err := cfg.Targets.validate(); err != nil {
+		return nil, err
+	}
+	if env := os.Getenv("MECHANIC_ALLOW_INTERNAL"); env != "" {
+		cfg.AllowInternal = splitList(env)
+	}
+	guard, err := NewAddrGuard(cfg.AllowInternal)
+	if err != nil {
+		return nil, err
+	}
+	cfg.guard = guard
+	if env := os.Getenv("MECHANIC_TOKENS"); env != "" {
+		for i, entry := range splitList(env) {
+			name, token, ok := strings.Cut(entry, "=")
+			if !ok {
+				name, token = fmt.Sprintf("env-%d", i+1), entry
+			}
+			cfg.Tokens = append(cfg.Tokens, APIToken{Name: name, Token: token})
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range cfg.Tokens {
+		t := &cfg.Tokens[i]
+		if t.Token == "" {
+			return nil, fmt.Errorf("token %d has no value", i+1)
+		}
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("token-%d", i+1)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicate token name %q", t.Name)
+		}
+		names[t.Name] = true
+		if t.Quota == nil {
+			quota := cfg.DefaultQuota
+			t.Quota = &quota
+		}
+	}
+	return cfg, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// AllowOrigin reports whether a browser on origin may call the API.
+func (c *ServerConfig) AllowOrigin(origin string) bool {
+	for _, o := range c.CORSOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimRight(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
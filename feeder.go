@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// maxRate caps open-loop schedules; above it the interval between two
+// requests would round down to nothing.
+const maxRate = 1e6 // requests/sec
+
+// feedStats is written by the feeder and read once the run has finished.
+type feedStats struct {
+	delayed int
+	dropped int
+}
+
+// openLoop reports whether requests follow a schedule rather than the
+// workers' pace.
+func (r *probeRun) openLoop() bool {
+	if len(r.req.Stages) > 0 {
+		return r.req.StageMode == StageModeRate
+	}
+	return r.req.Rate > 0
+}
+
+// workerCount is the pool size: the peak of a concurrency profile, or the
+// requested concurrency otherwise.
+func (r *probeRun) workerCount() int {
+	if len(r.req.Stages) > 0 && r.req.StageMode == StageModeConcurrency {
+		return int(math.Ceil(stagesMaxTarget(r.req.Stages)))
+	}
+	return r.req.Concurrency
+}
+
+// targetBuffer sizes the targets channel. In open-loop mode it is unbuffered
+// so that a successful non-blocking send means a worker was idle.
+func (r *probeRun) targetBuffer() int {
+	if r.openLoop() {
+		return 0
+	}
+	return r.workerCount()
+}
+
+// runFeeder streams work to the workers until the request count or the
+// deadline is reached, then closes targets so the workers drain and exit.
+func (r *probeRun) runFeeder(targets chan<- Target, start, deadline time.Time) {
+	defer close(targets)
+
+	switch {
+	case len(r.req.Stages) > 0 && r.openLoop():
+		r.feedStagedRate(targets, start)
+	case len(r.req.Stages) > 0:
+		r.feedStagedConcurrency(targets, start)
+	case r.openLoop():
+		r.feedRate(targets, deadline)
+	default:
+		r.feedClosed(targets, deadline)
+	}
+}
+
+// more reports whether request number i (0-based), due at t, should be sent.
+func (r *probeRun) more(i int, t time.Time, deadline time.Time) bool {
+	if r.capped(i) {
+		return false
+	}
+	if !deadline.IsZero() {
+		return t.Before(deadline)
+	}
+	return i < r.req.Count
+}
+
+// capped reports whether i requests use up ProbeRequest.MaxRequests.
+func (r *probeRun) capped(i int) bool {
+	return r.req.MaxRequests > 0 && i >= r.req.MaxRequests
+}
+
+// feedClosed hands out work as fast as the workers take it.
+func (r *probeRun) feedClosed(targets chan<- Target, deadline time.Time) {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for i := 0; r.more(i, time.Now(), deadline); i++ {
+		select {
+		case targets <- Target{Spec: r.mix.Pick()}:
+		case <-expired:
+			return
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// feedRate schedules requests on a fixed timeline, independent of how fast
+// responses come back. A request that finds no idle worker is sent as soon
+// as one frees up (delayed), unless it is already older than the request
+// timeout, in which case it is dropped.
+func (r *probeRun) feedRate(targets chan<- Target, deadline time.Time) {
+	interval := time.Duration(float64(time.Second) / r.req.Rate)
+	maxLag := time.Duration(r.req.Timeout) * time.Second
+	start := time.Now()
+
+	for i := 0; ; i++ {
+		at := start.Add(time.Duration(i) * interval)
+		if !r.more(i, at, deadline) || !r.sleepUntil(at) {
+			return
+		}
+
+		r.sendScheduled(targets, Target{Spec: r.mix.Pick(), Scheduled: at}, maxLag)
+	}
+}
+
+// sendScheduled hands t to an idle worker, or waits for one to free up for
+// at most maxLag past the scheduled time.
+func (r *probeRun) sendScheduled(targets chan<- Target, t Target, maxLag time.Duration) {
+	select {
+	case targets <- t:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(time.Until(t.Scheduled.Add(maxLag)))
+	defer timer.Stop()
+	select {
+	case targets <- t:
+		r.feed.delayed++
+	case <-timer.C:
+		r.feed.dropped++
+	case <-r.ctx.Done():
+	}
+}
+
+// sleepUntil waits until t; it returns false if the run was stopped first.
+func (r *probeRun) sleepUntil(t time.Time) bool {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return r.ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
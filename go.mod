@@ -1,3 +1,3 @@
 module mechanic
 
-go 1.22.0
+go 1.24.0
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+)
+
+// errBlockedAddr is returned when the guard refuses to dial an address.
+var errBlockedAddr = errors.New("blocked internal address")
+
+// blockedPrefixes are refused on top of loopback, private (RFC1918 and
+// fc00::/7), link-local (which holds 169.254.169.254) and unspecified
+// addresses.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),         // "this network", reaches localhost
+	netip.MustParsePrefix("100.64.0.0/10"),     // carrier-grade NAT (RFC 6598), holds Alibaba Cloud metadata
+	netip.MustParsePrefix("64:ff9b::/96"),      // NAT64, embeds any IPv4 address
+	netip.MustParsePrefix("fd00:ec2::254/128"), // AWS metadata over IPv6
+}
+
+// AddrGuard keeps web API probes away from internal networks. It checks
+// the address actually dialed, after DNS resolution, so a name that
+// resolves (or rebinds) to an internal address is refused too.
+type AddrGuard struct {
+	allow []netip.Prefix // internal ranges explicitly opted in
+}
+
+// NewAddrGuard returns a guard that lets the allow CIDRs through.
+func NewAddrGuard(allow []string) (*AddrGuard, error) {
+	g := &AddrGuard{}
+	for _, cidr := range allow {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("allow_internal: %v", err)
+		}
+		g.allow = append(g.allow, prefix.Masked())
+	}
+	return g, nil
+}
+
+// Blocked reports whether addr is internal and not opted in.
+func (g *AddrGuard) Blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range g.allow {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Control is a net.Dialer hook; address is the resolved "ip:port".
+func (g *AddrGuard) Control(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBlockedAddr, address)
+	}
+	if g.Blocked(ap.Addr()) {
+		return fmt.Errorf("%w: %s", errBlockedAddr, ap.Addr())
+	}
+	return nil
+}
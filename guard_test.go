@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestAddrGuardBlocked(t *testing.T) {
+	guard, err := NewAddrGuard(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optIn, err := NewAddrGuard([]string{"10.1.0.0/16", "100.64.0.0/10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		guard   *AddrGuard
+		addr    string
+		blocked bool
+	}{
+		{"loopback", guard, "127.0.0.1", true},
+		{"loopback v6", guard, "::1", true},
+		{"mapped loopback", guard, "::ffff:127.0.0.1", true},
+		{"private", guard, "10.1.2.3", true},
+		{"private v6", guard, "fd12::1", true},
+		{"metadata", guard, "169.254.169.254", true},
+		{"aws metadata v6", guard, "fd00:ec2::254", true},
+		{"unspecified", guard, "0.0.0.0", true},
+		{"this network", guard, "0.1.2.3", true},
+		{"cgnat", guard, "100.64.0.1", true},
+		{"alibaba metadata", guard, "100.100.100.200", true},
+		{"nat64", guard, "64:ff9b::a9fe:a9fe", true},
+		{"public", guard, "93.184.215.14", false},
+		{"public v6", guard, "2606:2800:21f:cb07:6820:80da:af6b:8b2c", false},
+		{"mapped public", guard, "::ffff:93.184.215.14", false},
+		{"above cgnat", guard, "100.128.0.1", false},
+		{"opted in", optIn, "10.1.2.3", false},
+		{"opted in cgnat", optIn, "100.100.100.200", false},
+		{"outside opt-in", optIn, "10.2.0.1", true},
+		{"loopback not opted in", optIn, "127.0.0.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.guard.Blocked(netip.MustParseAddr(tt.addr)); got != tt.blocked {
+				t.Errorf("Blocked(%s) = %v, want %v", tt.addr, got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestAddrGuardControl(t *testing.T) {
+	guard, err := NewAddrGuard(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Control("tcp4", "169.254.169.254:80", nil); !errors.Is(err, errBlockedAddr) {
+		t.Errorf("Control(metadata) = %v, want errBlockedAddr", err)
+	}
+	if err := guard.Control("tcp4", "not-an-address", nil); !errors.Is(err, errBlockedAddr) {
+		t.Errorf("Control(unparsable) = %v, want errBlockedAddr", err)
+	}
+	if err := guard.Control("tcp6", "[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443", nil); err != nil {
+		t.Errorf("Control(public) = %v, want nil", err)
+	}
+}
+
+func TestNewAddrGuardInvalid(t *testing.T) {
+	if _, err := NewAddrGuard([]string{"10.0.0.0"}); err == nil {
+		t.Error("NewAddrGuard accepted a CIDR without a prefix length")
+	}
+}
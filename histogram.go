@@ -0,0 +1,242 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram is an HDR-style latency histogram with bounded memory.
+// Values are stored in microseconds using log-linear buckets: every power of
+// two is split into histSubBuckets/2 linear slots, which keeps the relative
+// error under 1% from 1µs up to roughly 71 minutes. Two histograms can be merged
+// without losing precision.
+type Histogram struct {
+	counts [histBucketCount]uint64
+	count  uint64
+	min    time.Duration
+	max    time.Duration
+	sum    float64 // nanoseconds
+	sumSq  float64 // nanoseconds squared
+}
+
+const (
+	histSubBucketBits = 7
+	histSubBuckets    = 1 << histSubBucketBits
+	histHalfBuckets   = histSubBuckets / 2
+	histMaxBits       = 32 // ~71 minutes in microseconds; larger values are clamped
+	histMaxValue      = int64(1)<<histMaxBits - 1
+	histBucketCount   = (histMaxBits - histSubBucketBits + 2) * histHalfBuckets
+)
+
+// LatencySummary is the exported view of a Histogram.
+type LatencySummary struct {
+	Count  uint64        `json:"count"`
+	Min    time.Duration `json:"min"`
+	Max    time.Duration `json:"max"`
+	Mean   time.Duration `json:"mean"`
+	StdDev time.Duration `json:"stddev"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P95    time.Duration `json:"p95"`
+	P99    time.Duration `json:"p99"`
+	P999   time.Duration `json:"p99_9"`
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func histBucketIndex(v int64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(uint64(v)) - histSubBucketBits
+	sub := int(v >> uint(exp))
+	return exp*histHalfBuckets + sub
+}
+
+// histBucketRange returns the inclusive [lo, hi] microsecond range of a bucket.
+func histBucketRange(idx int) (int64, int64) {
+	if idx < histSubBuckets {
+		return int64(idx), int64(idx)
+	}
+	exp := idx/histHalfBuckets - 1
+	sub := int64(idx%histHalfBuckets + histHalfBuckets)
+	lo := sub << uint(exp)
+	return lo, lo + (int64(1) << uint(exp)) - 1
+}
+
+// Record adds a single observation.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	us := int64(d / time.Microsecond)
+	if us > histMaxValue {
+		us = histMaxValue
+	}
+	h.counts[histBucketIndex(us)]++
+
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	f := float64(d)
+	h.sum += f
+	h.sumSq += f * f
+}
+
+// Merge folds other into h.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+	h.sumSq += other.sumSq
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+func (h *Histogram) StdDev() time.Duration {
+	if h.count < 2 {
+		return 0
+	}
+	mean := h.sum / float64(h.count)
+	variance := h.sumSq/float64(h.count) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return time.Duration(math.Sqrt(variance))
+}
+
+// Quantile returns the value at quantile q (0..1). The result is the midpoint
+// of the matching bucket, clamped to the observed min and max.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 1 {
+		return h.max
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if c == 0 || seen < rank {
+			continue
+		}
+		lo, hi := histBucketRange(i)
+		v := time.Duration((lo+hi)/2) * time.Microsecond
+		if v < h.min {
+			v = h.min
+		}
+		if v > h.max {
+			v = h.max
+		}
+		return v
+	}
+	return h.max
+}
+
+// CountAbove returns how many observations fell in buckets entirely above d.
+func (h *Histogram) CountAbove(d time.Duration) uint64 {
+	us := int64(d / time.Microsecond)
+	var n uint64
+	for i := len(h.counts) - 1; i >= 0; i-- {
+		if lo, _ := histBucketRange(i); lo <= us {
+			break
+		}
+		n += h.counts[i]
+	}
+	return n
+}
+
+func (h *Histogram) Summary() LatencySummary {
+	return LatencySummary{
+		Count:  h.Count(),
+		Min:    h.Min(),
+		Max:    h.Max(),
+		Mean:   h.Mean(),
+		StdDev: h.StdDev(),
+		P50:    h.Quantile(0.50),
+		P90:    h.Quantile(0.90),
+		P95:    h.Quantile(0.95),
+		P99:    h.Quantile(0.99),
+		P999:   h.Quantile(0.999),
+	}
+}
+
+// HistogramBin is one bar of a latency distribution.
+type HistogramBin struct {
+	Lo    time.Duration `json:"lo"`
+	Hi    time.Duration `json:"hi"`
+	Count uint64        `json:"count"`
+}
+
+// Distribution regroups the recorded values into n log-spaced bins between
+// min and max, for plotting.
+func (h *Histogram) Distribution(n int) []HistogramBin {
+	if h.count == 0 || n <= 0 {
+		return nil
+	}
+	lo := math.Max(float64(h.min/time.Microsecond), 1)
+	hi := math.Max(float64(h.max/time.Microsecond), lo+1)
+	step := math.Log(hi/lo) / float64(n)
+
+	bins := make([]HistogramBin, n)
+	for i := range bins {
+		bins[i].Lo = time.Duration(lo*math.Exp(step*float64(i))) * time.Microsecond
+		bins[i].Hi = time.Duration(lo*math.Exp(step*float64(i+1))) * time.Microsecond
+	}
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		bLo, bHi := histBucketRange(i)
+		mid := math.Max(float64(bLo+bHi)/2, lo)
+		idx := int(math.Log(mid/lo) / step)
+		if idx >= n {
+			idx = n - 1
+		}
+		if idx < 0 {
+			idx = 0
+		}
+		bins[idx].Count += c
+	}
+	return bins
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistBucketIndexRange(t *testing.T) {
+	values := []int64{0, 1, histSubBuckets - 1, histSubBuckets, histSubBuckets + 1, 1000, 4095, 4096, 123456, 1 << 31, histMaxValue}
+	for exp := 0; exp < histMaxBits; exp++ {
+		values = append(values, int64(1)<<exp-1, int64(1)<<exp, int64(1)<<exp+1)
+	}
+	for _, v := range values {
+		if v < 0 || v > histMaxValue {
+			continue
+		}
+		idx := histBucketIndex(v)
+		if idx < 0 || idx >= histBucketCount {
+			t.Fatalf("histBucketIndex(%d) = %d, outside [0, %d)", v, idx, histBucketCount)
+		}
+		lo, hi := histBucketRange(idx)
+		if v < lo || v > hi {
+			t.Errorf("%d maps to bucket %d = [%d, %d]", v, idx, lo, hi)
+		}
+		// The midpoint reported by Quantile stays within 1%
+		if mid := (lo + hi) / 2; v > 0 && abs64(mid-v)*100 > v {
+			t.Errorf("%d: bucket midpoint %d is more than 1%% off", v, mid)
+		}
+	}
+}
+
+func TestHistBucketsContiguous(t *testing.T) {
+	_, prevHi := histBucketRange(0)
+	for idx := 1; idx < histBucketCount; idx++ {
+		lo, hi := histBucketRange(idx)
+		if lo != prevHi+1 || hi < lo {
+			t.Fatalf("bucket %d = [%d, %d] does not follow %d", idx, lo, hi, prevHi)
+		}
+		if histBucketIndex(lo) != idx || histBucketIndex(hi) != idx {
+			t.Fatalf("bucket %d = [%d, %d] does not map back to itself", idx, lo, hi)
+		}
+		prevHi = hi
+	}
+	if prevHi != histMaxValue {
+		t.Errorf("last bucket ends at %d, want %d", prevHi, histMaxValue)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	if got := h.Quantile(0.5); got != 0 {
+		t.Errorf("empty Quantile(0.5) = %v, want 0", got)
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.5, 500 * time.Millisecond},
+		{0.9, 900 * time.Millisecond},
+		{0.99, 990 * time.Millisecond},
+		{0.999, 999 * time.Millisecond},
+		{1, 1000 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := h.Quantile(tt.q)
+		if diff := got - tt.want; diff < -tt.want/100 || diff > tt.want/100 {
+			t.Errorf("Quantile(%v) = %v, want %v within 1%%", tt.q, got, tt.want)
+		}
+	}
+	if got := h.Quantile(1); got != h.Max() {
+		t.Errorf("Quantile(1) = %v, want max %v", got, h.Max())
+	}
+}
+
+func TestHistogramClampsAndMerges(t *testing.T) {
+	h := NewHistogram()
+	h.Record(-time.Second)
+	h.Record(100 * time.Hour)
+	if h.Min() != 0 || h.Max() != 100*time.Hour {
+		t.Errorf("min, max = %v, %v", h.Min(), h.Max())
+	}
+	// Values above histMaxValue land in the last bucket
+	if got, top := h.Quantile(0.99), time.Duration(histMaxValue)*time.Microsecond; got < top*99/100 || got > top {
+		t.Errorf("Quantile(0.99) = %v, want the last bucket near %v", got, top)
+	}
+
+	a, b, all := NewHistogram(), NewHistogram(), NewHistogram()
+	for i := 1; i <= 200; i++ {
+		d := time.Duration(i*i) * time.Microsecond
+		all.Record(d)
+		if i%2 == 0 {
+			a.Record(d)
+		} else {
+			b.Record(d)
+		}
+	}
+	a.Merge(b)
+	if a.Summary() != all.Summary() {
+		t.Errorf("merged summary %+v differs from %+v", a.Summary(), all.Summary())
+	}
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// histogramBins is the number of bars in the HTML latency distribution.
+const histogramBins = 40
+
+// WriteHTML renders r as a single self-contained page: charts are inline SVG
+// and styles are embedded, so the file opens offline and can be attached
+// to a ticket as is.
+func (r *Report) WriteHTML(w io.Writer) error {
+	stats := r.Stats
+	config, _ := json.MarshalIndent(r.Config, "", "  ")
+
+	data := struct {
+		*Report
+		ErrorRate   float64
+		Latency     template.HTML
+		Throughput  template.HTML
+		Histogram   template.HTML
+		Statuses    []htmlStatusRow
+		Phases      []phaseRow
+		ConfigJSON  string
+		GeneratedAt string
+	}{
+		Report:      r,
+		ErrorRate:   errorRate(stats) * 100,
+		Statuses:    statusRows(stats),
+		Phases:      phaseRows(stats.Phases),
+		ConfigJSON:  string(config),
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if len(stats.Timeline) > 0 {
+		var xs []float64
+		var p50, p99, mean, rps, errs []float64
+		for _, b := range stats.Timeline {
+			xs = append(xs, b.Start.Seconds())
+			p50 = append(p50, durationMs(b.P50))
+			p99 = append(p99, durationMs(b.P99))
+			mean = append(mean, durationMs(b.Mean))
+			rps = append(rps, b.RPS)
+			errs = append(errs, float64(b.Errors))
+		}
+		data.Latency = svgChart(xs, "s", "ms", []chartSeries{
+			{Name: "p99", Color: "#d9485f", Values: p99},
+			{Name: "p50", Color: "#2f7ed8", Values: p50},
+			{Name: "mean", Color: "#8a8f98", Values: mean},
+		})
+		data.Throughput = svgChart(xs, "s", "req/s", []chartSeries{
+			{Name: "requests/s", Color: "#2f7ed8", Values: rps, Bars: true},
+			{Name: "errors", Color: "#d9485f", Values: errs, Bars: true},
+		})
+	}
+	if stats.Histogram != nil {
+		var xs, counts []float64
+		for _, b := range stats.Histogram.Distribution(histogramBins) {
+			xs = append(xs, durationMs(b.Lo))
+			counts = append(counts, float64(b.Count))
+		}
+		if len(xs) > 0 {
+			data.Histogram = svgChart(xs, "ms", "requests", []chartSeries{
+				{Name: "requests", Color: "#2f7ed8", Values: counts, Bars: true},
+			})
+		}
+	}
+
+	return htmlReportTemplate.Execute(w, data)
+}
+
+type htmlStatusRow struct {
+	Label   string
+	Count   int
+	Percent float64
+	Error   bool
+}
+
+func statusRows(stats ProbeStats) []htmlStatusRow {
+	var rows []htmlStatusRow
+	pct := func(n int) float64 {
+		if stats.TotalRequest == 0 {
+			return 0
+		}
+		return float64(n) * 100 / float64(stats.TotalRequest)
+	}
+	for _, code := range sortedCodes(stats.StatusCodes) {
+		n := stats.StatusCodes[code]
+		rows = append(rows, htmlStatusRow{Label: fmt.Sprint(code), Count: n, Percent: pct(n), Error: code >= 400})
+	}
+	for _, class := range sortedKeys(stats.ErrorClasses) {
+		n := stats.ErrorClasses[class]
+		rows = append(rows, htmlStatusRow{Label: class, Count: n, Percent: pct(n), Error: true})
+	}
+	return rows
+}
+
+type chartSeries struct {
+	Name   string
+	Color  string
+	Values []float64
+	Bars   bool // drawn as bars instead of a line
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*pow >= v {
+			return m * pow
+		}
+	}
+	return 10 * pow
+}
+
+func trimFloat(v float64) string {
+	if v >= 100 || v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	if v >= 1 {
+		return fmt.Sprintf("%.1f", v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// svgChart draws series over the shared x values (one point per x).
+func svgChart(xs []float64, xUnit, yUnit string, series []chartSeries) template.HTML {
+	const (
+		width, height            = 760, 220
+		left, right, top, bottom = 56, 12, 12, 28
+		plotW, plotH             = width - left - right, height - top - bottom
+		ticks                    = 4
+	)
+	n := len(xs)
+	var maxY float64
+	for _, s := range series {
+		for _, v := range s.Values {
+			maxY = math.Max(maxY, v)
+		}
+	}
+	maxY = niceCeil(maxY)
+
+	slot := float64(plotW) / float64(n)
+	x := func(i int) float64 { return left + slot*(float64(i)+0.5) }
+	y := func(v float64) float64 { return top + plotH - v/maxY*plotH }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" class="chart" role="img">`, width, height)
+	for i := 0; i <= ticks; i++ {
+		v := maxY * float64(i) / ticks
+		fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" class="grid"/>`, left, width-right, y(v), y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" class="ylabel">%s</text>`, left-6, y(v)+4, trimFloat(v))
+	}
+	fmt.Fprintf(&b, `<text x="4" y="%d" class="unit">%s</text>`, top+10, template.HTMLEscapeString(yUnit))
+
+	labels := n
+	if labels > 8 {
+		labels = 8
+	}
+	for i := 0; i < labels; i++ {
+		idx := i * (n - 1) / max(labels-1, 1)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" class="xlabel">%s%s</text>`, x(idx), height-8, trimFloat(xs[idx]), template.HTMLEscapeString(xUnit))
+	}
+
+	for _, s := range series {
+		if s.Bars {
+			bw := math.Max(slot*0.8, 1)
+			for i, v := range s.Values {
+				if v <= 0 {
+					continue
+				}
+				fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s%s: %s %s</title></rect>`,
+					x(i)-bw/2, y(v), bw, y(0)-y(v), s.Color, trimFloat(xs[i]), template.HTMLEscapeString(xUnit), trimFloat(v), template.HTMLEscapeString(s.Name))
+			}
+			continue
+		}
+		points := make([]string, len(s.Values))
+		for i, v := range s.Values {
+			points[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(v))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.8"/>`, strings.Join(points, " "), s.Color)
+	}
+	b.WriteString(`</svg><div class="legend">`)
+	for _, s := range series {
+		fmt.Fprintf(&b, `<span><i style="background:%s"></i>%s</span>`, s.Color, template.HTMLEscapeString(s.Name))
+	}
+	b.WriteString(`</div>`)
+	return template.HTML(b.String())
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms":  func(d time.Duration) string { return fmt.Sprintf("%.2f", durationMs(d)) },
+	"dur": func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+	"sorted": func(m map[string]string) [][2]string {
+		var out [][2]string
+		for k, v := range m {
+			out = append(out, [2]string{k, v})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+		return out
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Mechanic report - {{.Target}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; margin: 0; background: #f5f6f8; color: #1d2129; }
+main { max-width: 980px; margin: 0 auto; padding: 24px; }
+h1 { font-size: 22px; margin: 0 0 4px; word-break: break-all; }
+h2 { font-size: 16px; margin: 28px 0 10px; }
+.meta { color: #6b7280; font-size: 13px; }
+.cards { display: grid; grid-template-columns: repeat(auto-fill, minmax(140px, 1fr)); gap: 10px; margin-top: 18px; }
+.card { background: #fff; border-radius: 6px; padding: 12px; box-shadow: 0 1px 2px rgba(0,0,0,.06); }
+.card b { display: block; font-size: 20px; }
+.card span { color: #6b7280; font-size: 12px; }
+.panel { background: #fff; border-radius: 6px; padding: 14px; box-shadow: 0 1px 2px rgba(0,0,0,.06); }
+table { border-collapse: collapse; width: 100%; font-size: 13px; }
+th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #eceef1; }
+th { color: #6b7280; font-weight: 600; }
+td.num, th.num { text-align: right; font-variant-numeric: tabular-nums; }
+.bar { background: #2f7ed8; height: 8px; border-radius: 2px; }
+.bar.err { background: #d9485f; }
+.fail { color: #d9485f; font-weight: 600; }
+.pass { color: #1f9d55; font-weight: 600; }
+.chart { width: 100%; height: auto; }
+.chart .grid { stroke: #eceef1; }
+.chart text { font-size: 11px; fill: #6b7280; }
+.chart .ylabel { text-anchor: end; }
+.chart .xlabel { text-anchor: middle; }
+.legend { font-size: 12px; color: #6b7280; }
+.legend span { margin-right: 14px; }
+.legend i { display: inline-block; width: 10px; height: 10px; margin-right: 4px; border-radius: 2px; }
+pre { background: #f5f6f8; padding: 10px; overflow: auto; font-size: 12px; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Target}}</h1>
+<div class="meta">{{time .StartedAt}} &rarr; {{time .FinishedAt}} &middot; {{.Tool}} ({{.GoVersion}})</div>
+{{with .Stats}}{{if .Aborted}}<p class="fail">Aborted: {{.Aborted}}</p>{{end}}{{end}}
+
+<div class="cards">
+<div class="card"><b>{{.Stats.TotalRequest}}</b><span>requests</span></div>
+<div class="card"><b>{{.Stats.SuccessCount}}</b><span>successful</span></div>
+<div class="card"><b>{{.Stats.ErrorCount}}</b><span>failed ({{printf "%.2f" .ErrorRate}}%)</span></div>
+<div class="card"><b>{{printf "%.1f" .Stats.Throughput}}</b><span>requests/s</span></div>
+<div class="card"><b>{{ms .Stats.Latency.P50}}</b><span>p50 ms</span></div>
+<div class="card"><b>{{ms .Stats.Latency.P99}}</b><span>p99 ms</span></div>
+<div class="card"><b>{{dur .Stats.Elapsed}}</b><span>elapsed</span></div>
+</div>
+
+{{if .Latency}}<h2>Latency over time</h2>
+<div class="panel">{{.Latency}}</div>
+<h2>Throughput over time</h2>
+<div class="panel">{{.Throughput}}</div>{{end}}
+
+{{if .Histogram}}<h2>Latency distribution</h2>
+<div class="panel">{{.Histogram}}</div>{{end}}
+
+<h2>Latency (ms)</h2>
+<div class="panel"><table>
+<tr><th class="num">min</th><th class="num">mean</th><th class="num">stddev</th><th class="num">p50</th><th class="num">p90</th><th class="num">p95</th><th class="num">p99</th><th class="num">p99.9</th><th class="num">max</th></tr>
+{{with .Stats.Latency}}<tr><td class="num">{{ms .Min}}</td><td class="num">{{ms .Mean}}</td><td class="num">{{ms .StdDev}}</td><td class="num">{{ms .P50}}</td><td class="num">{{ms .P90}}</td><td class="num">{{ms .P95}}</td><td class="num">{{ms .P99}}</td><td class="num">{{ms .P999}}</td><td class="num">{{ms .Max}}</td></tr>{{end}}
+</table>
+{{with .Stats.FirstHop}}<p class="meta">First hop ({{$.Stats.Redirected}} redirected): mean {{ms .Mean}} / p50 {{ms .P50}} / p99 {{ms .P99}} ms; the table covers the full chain</p>{{end}}</div>
+
+{{if .Statuses}}<h2>Responses</h2>
+<div class="panel"><table>
+<tr><th>Status / error</th><th class="num">Count</th><th class="num">%</th><th style="width:45%"></th></tr>
+{{range .Statuses}}<tr><td>{{.Label}}</td><td class="num">{{.Count}}</td><td class="num">{{printf "%.1f" .Percent}}</td><td><div class="bar{{if .Error}} err{{end}}" style="width:{{printf "%.1f" .Percent}}%"></div></td></tr>
+{{end}}</table></div>{{end}}
+
+<h2>Phases (ms)</h2>
+<div class="panel"><table>
+<tr><th>Phase</th><th class="num">mean</th><th class="num">p50</th><th class="num">p99</th></tr>
+{{range .Phases}}<tr><td>{{.Name}}</td><td class="num">{{ms .Summary.Mean}}</td><td class="num">{{ms .Summary.P50}}</td><td class="num">{{ms .Summary.P99}}</td></tr>
+{{end}}</table>
+<p class="meta">Connections: {{.Stats.Phases.NewConns}} new / {{.Stats.Phases.ReusedConns}} reused{{with .Stats.Phases}}{{if and .NewConns .ReusedConns}} &middot; TTFB mean {{ms .NewConnTTFB.Mean}} ms new / {{ms .ReusedConnTTFB.Mean}} ms reused{{end}}{{end}}</p>
+{{if .Stats.TLS}}<p class="meta">TLS: {{range $label, $n := .Stats.TLS}}{{$label}} ({{$n}}) {{end}}</p>{{end}}</div>
+
+{{if .Stats.Stages}}<h2>Stages</h2>
+<div class="panel"><table>
+<tr><th>Name</th><th class="num">Time</th><th class="num">Requests</th><th class="num">req/s</th><th class="num">Errors</th><th class="num">p50 ms</th><th class="num">p99 ms</th></tr>
+{{range .Stats.Stages}}<tr><td>{{.Name}}</td><td class="num">{{dur .Elapsed}}</td><td class="num">{{.TotalRequest}}</td><td class="num">{{printf "%.1f" .Throughput}}</td><td class="num">{{.ErrorCount}}</td><td class="num">{{ms .Latency.P50}}</td><td class="num">{{ms .Latency.P99}}</td></tr>
+{{end}}</table></div>{{end}}
+
+{{if .Stats.Requests}}<h2>Requests</h2>
+<div class="panel"><table>
+<tr><th>Name</th><th class="num">Requests</th><th class="num">req/s</th><th class="num">Errors</th><th class="num">p50 ms</th><th class="num">p99 ms</th></tr>
+{{range .Stats.Requests}}<tr><td>{{.Name}}</td><td class="num">{{.TotalRequest}}</td><td class="num">{{printf "%.1f" .Throughput}}</td><td class="num">{{.ErrorCount}}</td><td class="num">{{ms .Latency.P50}}</td><td class="num">{{ms .Latency.P99}}</td></tr>
+{{end}}</table></div>{{end}}
+
+{{if .Stats.Protocols}}<h2>Protocols</h2>
+<div class="panel"><table>
+<tr><th>Protocol</th><th class="num">Requests</th><th class="num">req/s</th><th class="num">Errors</th><th class="num">p50 ms</th><th class="num">p99 ms</th></tr>
+{{range .Stats.Protocols}}<tr><td>{{.Name}}</td><td class="num">{{.TotalRequest}}</td><td class="num">{{printf "%.1f" .Throughput}}</td><td class="num">{{.ErrorCount}}</td><td class="num">{{ms .Latency.P50}}</td><td class="num">{{ms .Latency.P99}}</td></tr>
+{{end}}</table></div>{{end}}
+
+{{if .Stats.Addrs}}<h2>Addresses</h2>
+<div class="panel"><table>
+<tr><th>Address</th><th class="num">Requests</th><th class="num">req/s</th><th class="num">Errors</th><th class="num">p50 ms</th><th class="num">p99 ms</th></tr>
+{{range .Stats.Addrs}}<tr><td>{{.Name}}</td><td class="num">{{.TotalRequest}}</td><td class="num">{{printf "%.1f" .Throughput}}</td><td class="num">{{.ErrorCount}}</td><td class="num">{{ms .Latency.P50}}</td><td class="num">{{ms .Latency.P99}}</td></tr>
+{{end}}</table></div>{{end}}
+
+{{if .Stats.Checks}}<h2>Checks</h2>
+<div class="panel"><table>
+<tr><th>Check</th><th class="num">Passes</th><th class="num">Fails</th></tr>
+{{range .Stats.Checks}}<tr><td>{{.Name}}</td><td class="num">{{.Passes}}</td><td class="num{{if .Fails}} fail{{end}}">{{.Fails}}</td></tr>
+{{end}}</table></div>{{end}}
+
+{{if .Stats.Thresholds}}<h2>Thresholds</h2>
+<div class="panel"><table>
+<tr><th>Threshold</th><th class="num">Actual</th><th>Result</th></tr>
+{{range .Stats.Thresholds}}<tr><td><code>{{.Expr}}</code></td><td class="num">{{printf "%.4g" .Actual}}</td><td>{{if .OK}}<span class="pass">PASS</span>{{else}}<span class="fail">FAIL</span>{{end}}</td></tr>
+{{end}}</table></div>{{end}}
+
+<h2>Configuration</h2>
+<div class="panel">
+<table>
+<tr><th>URL</th><td>{{.Config.URL}}</td></tr>
+<tr><th>Method</th><td>{{or .Config.Method "default"}}</td></tr>
+<tr><th>Concurrency</th><td>{{.Config.Concurrency}}</td></tr>
+{{if .Config.Rate}}<tr><th>Rate</th><td>{{.Config.Rate}} req/s</td></tr>{{end}}
+{{if .Config.Duration}}<tr><th>Duration</th><td>{{.Config.Duration}}s</td></tr>{{else if not .Config.Stages}}<tr><th>Count</th><td>{{.Config.Count}}</td></tr>{{end}}
+<tr><th>Timeout</th><td>{{.Config.Timeout}}s</td></tr>
+{{range sorted .Config.Headers}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+<pre>{{.ConfigJSON}}</pre>
+</div>
+
+<p class="meta">Generated {{.GeneratedAt}}</p>
+</main>
+</body>
+</html>
+`))
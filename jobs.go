@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobCancelled = "cancelled"
+)
+
+// jobHistory is how many finished jobs are kept per token for GET /api/jobs.
+const jobHistory = 100
+
+// errJobCancelled is the cancel cause of DELETE /api/jobs/{id}; it ends up
+// in ProbeStats.Aborted.
+var errJobCancelled = errors.New("cancelled via API")
+
+// Job is a probe started with POST /api/jobs.
+type Job struct {
+	mu       sync.Mutex
+	id       string
+	owner    string // token name, empty when the API is open
+	status   string
+	req      ProbeRequest
+	created  time.Time
+	finished time.Time
+	stats    ProbeStats // live snapshot, final once finished
+	cancel   context.CancelCauseFunc
+}
+
+// JobView is the JSON form of a Job.
+type JobView struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	URL        string        `json:"url"`
+	CreatedAt  time.Time     `json:"created_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Progress   int           `json:"progress"`
+	Total      int           `json:"total"` // 0 for duration and stage runs
+	Request    *ProbeRequest `json:"request,omitempty"`
+	Stats      *ProbeStats   `json:"stats,omitempty"`
+}
+
+// View snapshots the job; full adds the request and stats.
+func (j *Job) View(full bool) JobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	v := JobView{
+		ID:        j.id,
+		Status:    j.status,
+		URL:       j.req.URL,
+		CreatedAt: j.created,
+		Progress:  j.stats.TotalRequest,
+	}
+	if j.req.Duration == 0 && len(j.req.Stages) == 0 {
+		v.Total = j.req.Count
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		v.FinishedAt = &finished
+	}
+	if full {
+		req, stats := redactRequest(j.req), j.stats
+		v.Request, v.Stats = &req, &stats
+	}
+	return v
+}
+
+// jobStore tracks running jobs and the most recent finished ones.
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+var jobs = &jobStore{jobs: make(map[string]*Job)}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start validates req and runs it in the background once admit, which
+// takes the caller's run budget, lets it through.
+func (s *jobStore) Start(req ProbeRequest, owner string, admit func() error) (*Job, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	run, err := newProbeRun(ctx, req)
+	if err == nil {
+		err = admit()
+	}
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+
+	job := &Job{id: newJobID(), owner: owner, status: JobRunning, req: req, created: time.Now(), cancel: cancel}
+	job.stats.TargetURL = req.URL
+	s.mu.Lock()
+	s.jobs[job.id] = job
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel(nil)
+		snapshots := 0
+		stats := run.Run(func(res Result, collector *statsCollector) {
+			// Same cadence as the SSE stream
+			if collector.stats.TotalRequest%50 != 0 && collector.series.Len() == snapshots {
+				return
+			}
+			snapshots = collector.series.Len()
+			snap := collector.Stats()
+			job.mu.Lock()
+			job.stats = snap
+			job.mu.Unlock()
+		})
+
+		job.mu.Lock()
+		job.stats = stats
+		job.finished = time.Now()
+		job.status = JobDone
+		if errors.Is(context.Cause(ctx), errJobCancelled) {
+			job.status = JobCancelled
+		}
+		job.mu.Unlock()
+		fmt.Printf("[JOB] %s %s -> Success: %d | Errors: %d | p99: %v\n", job.id, job.status, stats.SuccessCount, stats.ErrorCount, stats.Latency.P99)
+		s.prune(owner)
+	}()
+	return job, nil
+}
+
+// Get finds a job of owner; other tokens' jobs are not visible.
+func (s *jobStore) Get(id, owner string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.owner != owner {
+		return nil, false
+	}
+	return job, true
+}
+
+// List returns the jobs of owner, newest first.
+func (s *jobStore) List(owner string) []*Job {
+	s.mu.Lock()
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if job.owner == owner {
+			list = append(list, job)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, k int) bool { return list[i].created.After(list[k].created) })
+	return list
+}
+
+// prune drops the oldest finished jobs of owner beyond jobHistory.
+func (s *jobStore) prune(owner string) {
+	var finished []*Job
+	for _, job := range s.List(owner) {
+		if job.View(false).FinishedAt != nil {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= jobHistory {
+		return
+	}
+	s.mu.Lock()
+	for _, job := range finished[jobHistory:] {
+		delete(s.jobs, job.id)
+	}
+	s.mu.Unlock()
+}
+
+func ownerName(token *APIToken) string {
+	if token == nil {
+		return ""
+	}
+	return token.Name
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleCreateJob - POST /api/jobs
+func handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var req ProbeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err := sanitizeRequest(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	job, err := jobs.Start(req, ownerName(tokenFrom(r)), func() error { return admitRun(r, &req) })
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	fmt.Printf("[JOB] %s started -> Target: %s | Workers: %d | Total: %d\n", job.id, req.URL, req.Concurrency, req.Count)
+
+	w.Header().Set("Location", "/api/jobs/"+job.id)
+	writeJSON(w, http.StatusAccepted, job.View(false))
+}
+
+// handleListJobs - GET /api/jobs
+func handleListJobs(w http.ResponseWriter, r *http.Request) {
+	list := jobs.List(ownerName(tokenFrom(r)))
+	views := make([]JobView, len(list))
+	for i, job := range list {
+		views[i] = job.View(false)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": views})
+}
+
+// handleGetJob - GET /api/jobs/{id}
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.Get(r.PathValue("id"), ownerName(tokenFrom(r)))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job.View(true))
+}
+
+// handleCancelJob - DELETE /api/jobs/{id}
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.Get(r.PathValue("id"), ownerName(tokenFrom(r)))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.View(false).Status != JobRunning {
+		http.Error(w, "Job already finished", http.StatusConflict)
+		return
+	}
+	job.cancel(errJobCancelled)
+	fmt.Printf("[JOB] %s cancel requested\n", job.id)
+	writeJSON(w, http.StatusAccepted, job.View(false))
+}
@@ -1,47 +1,331 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ProbeStats struct {
+	Name         string        `json:"name,omitempty"`
 	TargetURL    string        `json:"target_url"`
 	TotalRequest int           `json:"total_requests"`
 	SuccessCount int           `json:"success_count"`
 	ErrorCount   int           `json:"error_count"`
 	AvgLatency   time.Duration `json:"avg_latency"`
+
+	// Latency covers every request that got a response, whatever its status.
+	Latency   LatencySummary `json:"latency"`
+	Histogram *Histogram     `json:"-"`
+
+	StatusCodes  map[int]int    `json:"status_codes"`
+	ErrorClasses map[string]int `json:"error_classes"`
+	TLS          map[string]int `json:"tls,omitempty"` // responses per negotiated "version cipher"
+
+	// Redirect following only: responses that were redirected, and latency
+	// up to the first response of each chain
+	Redirected int             `json:"redirected,omitempty"`
+	FirstHop   *LatencySummary `json:"first_hop,omitempty"`
+
+	Phases PhaseStats `json:"phases"`
+
+	// Requests, errors and latency per ProbeRequest.Bucket of the run
+	Timeline []TimeBucket `json:"timeline,omitempty"`
+
+	// Wall-clock time of the run and achieved requests/sec
+	Elapsed    time.Duration `json:"elapsed"`
+	Throughput float64       `json:"throughput"`
+
+	// Open-loop mode only: scheduled requests sent late or not at all
+	// because every worker was busy.
+	Rate    float64 `json:"rate,omitempty"`
+	Delayed int     `json:"delayed"`
+	Dropped int     `json:"dropped"`
+
+	// Per-stage breakdown when a load profile is used
+	Stages []ProbeStats `json:"stages,omitempty"`
+	// Per-request breakdown when a scenario is used
+	Requests []ProbeStats `json:"requests,omitempty"`
+	// Per-protocol breakdown of requests that got a response
+	Protocols []ProbeStats `json:"protocols,omitempty"`
+	// Per server IP, when requests went to more than one address or
+	// addresses were pinned
+	Addrs []ProbeStats `json:"addrs,omitempty"`
+
+	// Response assertions; a request failing any check counts as an error
+	ChecksFailed int          `json:"checks_failed"`
+	Checks       []CheckStats `json:"checks,omitempty"`
+
+	// Pass/fail gates evaluated on the final stats
+	Thresholds       []ThresholdResult `json:"thresholds,omitempty"`
+	ThresholdsPassed bool              `json:"thresholds_passed"`
+	Aborted          string            `json:"aborted,omitempty"` // why the run was stopped early
 }
 
-func PerformProbe(targetURL string, concurrency int, requestCount int, timeoutSec int) ProbeStats {
-	// Use all CPU cores
-	runtime.GOMAXPROCS(runtime.NumCPU())
+// statsCollector aggregates Results into a ProbeStats.
+type statsCollector struct {
+	stats    ProbeStats
+	hist     *Histogram
+	firstHop *Histogram
+	phases   *phaseCollector
+	checks   *checkCollector
+	success  StatusSet
+	series   *timeline // optional
+}
+
+func newStatsCollector(targetURL string, success StatusSet, checkNames []string) *statsCollector {
+	return &statsCollector{
+		stats: ProbeStats{
+			TargetURL:    targetURL,
+			StatusCodes:  make(map[int]int),
+			ErrorClasses: make(map[string]int),
+		},
+		hist:     NewHistogram(),
+		firstHop: NewHistogram(),
+		phases:   newPhaseCollector(),
+		checks:   newCheckCollector(checkNames),
+		success:  success,
+	}
+}
+
+func (c *statsCollector) Add(res Result) {
+	c.stats.TotalRequest++
+	if res.Err != nil {
+		c.stats.ErrorCount++
+		c.stats.ErrorClasses[res.ErrorClass]++
+		if c.series != nil {
+			c.series.Add(res, true)
+		}
+		return
+	}
+
+	c.stats.StatusCodes[res.StatusCode]++
+	if res.TLSVersion != "" {
+		if c.stats.TLS == nil {
+			c.stats.TLS = make(map[string]int)
+		}
+		c.stats.TLS[res.TLSVersion+" "+res.TLSCipher]++
+	}
+	c.hist.Record(res.Duration)
+	if res.Redirects > 0 {
+		c.stats.Redirected++
+		c.firstHop.Record(res.FirstHop)
+	} else {
+		c.firstHop.Record(res.Duration)
+	}
+	c.phases.Add(res.Phases)
+	c.checks.Add(res)
+	passed := res.ChecksPassed()
+	if !passed {
+		c.stats.ChecksFailed++
+	}
+	ok := passed && c.success.Contains(res.StatusCode)
+	if ok {
+		c.stats.SuccessCount++
+	} else {
+		c.stats.ErrorCount++
+	}
+	if c.series != nil {
+		c.series.Add(res, !ok)
+	}
+}
+
+// Stats returns a snapshot with the latency fields filled in.
+func (c *statsCollector) Stats() ProbeStats {
+	stats := c.stats
+	stats.AvgLatency = c.hist.Mean()
+	stats.Latency = c.hist.Summary()
+	if c.stats.Redirected > 0 {
+		firstHop := c.firstHop.Summary()
+		stats.FirstHop = &firstHop
+	}
+	stats.Histogram = c.hist
+	stats.Phases = c.phases.Stats()
+	stats.Checks = c.checks.Stats()
+	stats.Timeline = c.series.Buckets()
+
+	stats.StatusCodes = make(map[int]int, len(c.stats.StatusCodes))
+	for code, n := range c.stats.StatusCodes {
+		stats.StatusCodes[code] = n
+	}
+	stats.ErrorClasses = make(map[string]int, len(c.stats.ErrorClasses))
+	for class, n := range c.stats.ErrorClasses {
+		stats.ErrorClasses[class] = n
+	}
+	if c.stats.TLS != nil {
+		stats.TLS = make(map[string]int, len(c.stats.TLS))
+		for label, n := range c.stats.TLS {
+			stats.TLS[label] = n
+		}
+	}
+	return stats
+}
+
+// keyedCollectors splits Results by a key such as the protocol; results
+// with an empty key are left out.
+type keyedCollectors struct {
+	url        string
+	success    StatusSet
+	checkNames []string
+	byKey      map[string]*statsCollector
+}
+
+func newKeyedCollectors(url string, success StatusSet, checkNames []string) *keyedCollectors {
+	return &keyedCollectors{url: url, success: success, checkNames: checkNames, byKey: make(map[string]*statsCollector)}
+}
+
+func (k *keyedCollectors) Add(key string, res Result) {
+	if key == "" {
+		return
+	}
+	c, ok := k.byKey[key]
+	if !ok {
+		c = newStatsCollector(k.url, k.success, k.checkNames)
+		k.byKey[key] = c
+	}
+	c.Add(res)
+}
+
+// Stats returns one ProbeStats per key, sorted by key.
+func (k *keyedCollectors) Stats(elapsed time.Duration) []ProbeStats {
+	keys := make([]string, 0, len(k.byKey))
+	for key := range k.byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var out []ProbeStats
+	for _, key := range keys {
+		stats := k.byKey[key].Stats()
+		stats.Name = key
+		stats.Elapsed = elapsed
+		if elapsed > 0 {
+			stats.Throughput = float64(stats.TotalRequest) / elapsed.Seconds()
+		}
+		out = append(out, stats)
+	}
+	return out
+}
+
+// probeRun holds everything needed to execute one probe.
+type probeRun struct {
+	req     ProbeRequest
+	mix     *requestMix
+	success StatusSet
+	client  *http.Client
+	feed    feedStats
+	log     *resultLog // nil unless ProbeRequest.ResultLog is set
+
+	thresholds []Threshold
+	parent     context.Context // the caller's; its cancellation aborts the run
+	ctx        context.Context // cancelled to stop feeding early
+	stop       context.CancelFunc
+
+	// Concurrency profiles only: requests in flight, and a completion signal
+	inflight  atomic.Int64
+	completed chan struct{}
+}
+
+// newProbeRun validates req; no request is sent until Run is called.
+// Cancelling ctx stops the run, which then returns partial stats.
+func newProbeRun(ctx context.Context, req ProbeRequest) (*probeRun, error) {
+	success, err := ParseStatusSet(req.SuccessStatus)
+	if err != nil {
+		return nil, err
+	}
+	mix, err := newRequestMix(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range mix.specs {
+		if err := checkProtocolScheme(req.Protocol, spec.URL); err != nil {
+			return nil, err
+		}
+	}
+	if len(req.Stages) > 0 && req.StageMode == "" {
+		req.StageMode = StageModeRate
+	}
+	if err := validateStages(req.Stages, req.StageMode); err != nil {
+		return nil, err
+	}
+	thresholds, err := ParseThresholds(req.Thresholds)
+	if err != nil {
+		return nil, err
+	}
+
+	if math.IsNaN(req.Rate) || req.Rate < 0 || req.Rate > maxRate {
+		return nil, fmt.Errorf("rate must be between 0 and %g req/s", maxRate)
+	}
+	if req.MaxConnsPerHost < 0 || req.IdleConns < 0 || req.DialTimeout < 0 || req.TLSHandshakeTimeout < 0 {
+		return nil, fmt.Errorf("connection limits and timeouts must not be negative")
+	}
+	if req.Bucket != 0 && req.Bucket < minBucket.Seconds() {
+		return nil, fmt.Errorf("bucket must be at least %v", minBucket)
+	}
 
 	// Shorter timeout for faster failure detection
-	if timeoutSec > 5 {
-		timeoutSec = 5
+	if req.Timeout > 5 {
+		req.Timeout = 5
+	}
+
+	client, err := NewClient(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var log *resultLog
+	if req.ResultLog != nil {
+		log = newResultLog(req.ResultLog, req.Stages)
 	}
 
-	client := NewClient(time.Duration(timeoutSec) * time.Second)
+	runCtx, stop := context.WithCancel(ctx)
+	return &probeRun{
+		req:        req,
+		mix:        mix,
+		success:    success,
+		client:     client,
+		log:        log,
+		thresholds: thresholds,
+		parent:     ctx,
+		ctx:        runCtx,
+		stop:       stop,
+		completed:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Run starts the workers and the feeder, then collects every Result.
+// onResult, if set, is called after each Result is added.
+func (r *probeRun) Run(onResult func(res Result, c *statsCollector)) ProbeStats {
+	defer r.stop()
+	// Use all CPU cores
+	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	// Buffered channels for zero-blocking
-	targets := make(chan string, requestCount)
-	results := make(chan Result, requestCount)
+	workers := r.workerCount()
+	targets := make(chan Target, r.targetBuffer())
+	results := make(chan Result, workers)
 
 	var wg sync.WaitGroup
 
 	// Start workers BEFORE feeding targets (pipeline optimization)
-	for i := 0; i < concurrency; i++ {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go Worker(i, targets, results, client, &wg)
+		go Worker(r.ctx, i, targets, results, r.client, &wg)
 	}
 
-	// Feed all targets instantly (non-blocking because buffer is big enough)
-	for i := 0; i < requestCount; i++ {
-		targets <- targetURL
+	start := time.Now()
+	var deadline time.Time
+	if len(r.req.Stages) > 0 {
+		deadline = start.Add(stagesDuration(r.req.Stages))
+	} else if r.req.Duration > 0 {
+		deadline = start.Add(time.Duration(r.req.Duration * float64(time.Second)))
 	}
-	close(targets)
+	go r.runFeeder(targets, start, deadline)
 
 	// Collect results in background
 	go func() {
@@ -49,24 +333,130 @@ func PerformProbe(targetURL string, concurrency int, requestCount int, timeoutSe
 		close(results)
 	}()
 
-	stats := ProbeStats{
-		TargetURL:    targetURL,
-		TotalRequest: requestCount,
+	checkNames := r.mix.CheckNames()
+	collector := newStatsCollector(r.req.URL, r.success, checkNames)
+	collector.series = newTimeline(start, bucketWidth(r.req.Bucket))
+	stageCollectors := make([]*statsCollector, len(r.req.Stages))
+	for i := range stageCollectors {
+		stageCollectors[i] = newStatsCollector(r.req.URL, r.success, checkNames)
+	}
+
+	var requestCollectors map[string]*statsCollector
+	if r.mix.Named() {
+		requestCollectors = make(map[string]*statsCollector, len(r.mix.specs))
+		for _, spec := range r.mix.specs {
+			requestCollectors[spec.Name] = newStatsCollector(spec.URL, r.success, spec.Checks.Names())
+		}
 	}
 
-	var totalTime time.Duration
+	protocols := newKeyedCollectors(r.req.URL, r.success, checkNames)
+	addrs := newKeyedCollectors(r.req.URL, r.success, checkNames)
+
+	var aborted string
 	for res := range results {
-		if res.Err != nil {
-			stats.ErrorCount++
-		} else {
-			stats.SuccessCount++
-			totalTime += res.Duration
+		collector.Add(res)
+		if r.log != nil {
+			r.log.Write(res)
+		}
+		if len(stageCollectors) > 0 {
+			stageCollectors[res.Stage].Add(res)
+		}
+		if requestCollectors != nil {
+			requestCollectors[res.Name].Add(res)
+		}
+		protocols.Add(res.Proto, res)
+		addrs.Add(res.Addr, res)
+		r.inflight.Add(-1)
+		select {
+		case r.completed <- struct{}{}:
+		default:
+		}
+		if onResult != nil {
+			onResult(res, collector)
+		}
+		if aborted == "" && r.req.AbortOnFail && collector.stats.TotalRequest%50 == 0 {
+			if t, ok := r.breachedThreshold(collector.Stats()); ok {
+				aborted = "threshold " + t.Expr + " breached"
+				r.stop()
+			}
 		}
 	}
 
-	if stats.SuccessCount > 0 {
-		stats.AvgLatency = totalTime / time.Duration(stats.SuccessCount)
+	if aborted == "" && r.parent.Err() != nil {
+		aborted = context.Cause(r.parent).Error()
 	}
 
+	// The feeder has closed targets before the workers exited
+	stats := collector.Stats()
+	stats.Elapsed = time.Since(start)
+	if stats.Elapsed > 0 {
+		stats.Throughput = float64(stats.TotalRequest) / stats.Elapsed.Seconds()
+	}
+	stats.Rate = r.req.Rate
+	stats.Delayed = r.feed.delayed
+	stats.Dropped = r.feed.dropped
+	stats.Aborted = aborted
+
+	for i, c := range stageCollectors {
+		st := r.req.Stages[i]
+		stageStats := c.Stats()
+		stageStats.Name = st.Name
+		stageStats.Elapsed = time.Duration(st.Duration * float64(time.Second))
+		if stageStats.Elapsed > 0 {
+			stageStats.Throughput = float64(stageStats.TotalRequest) / stageStats.Elapsed.Seconds()
+		}
+		stats.Stages = append(stats.Stages, stageStats)
+	}
+	for _, spec := range r.mix.specs {
+		if c, ok := requestCollectors[spec.Name]; ok {
+			reqStats := c.Stats()
+			reqStats.Name = spec.Name
+			reqStats.Elapsed = stats.Elapsed
+			if reqStats.Elapsed > 0 {
+				reqStats.Throughput = float64(reqStats.TotalRequest) / reqStats.Elapsed.Seconds()
+			}
+			stats.Requests = append(stats.Requests, reqStats)
+		}
+	}
+	stats.Protocols = protocols.Stats(stats.Elapsed)
+	if len(addrs.byKey) > 1 || len(r.req.Resolve) > 0 || r.req.RoundRobin {
+		stats.Addrs = addrs.Stats(stats.Elapsed)
+	}
+	stats.Thresholds, stats.ThresholdsPassed = EvaluateThresholds(r.thresholds, stats)
 	return stats
 }
+
+// breachedThreshold returns the first threshold that can no longer pass.
+func (r *probeRun) breachedThreshold(stats ProbeStats) (Threshold, bool) {
+	planned := r.req.Count
+	if r.req.Duration > 0 || len(r.req.Stages) > 0 {
+		planned = 0
+	}
+	for _, t := range r.thresholds {
+		if t.Breached(stats, planned) {
+			return t, true
+		}
+	}
+	return Threshold{}, false
+}
+
+// errResultLog wraps a failure to write the result log.
+var errResultLog = errors.New("writing results")
+
+// PerformProbe runs a probe described by req and blocks until it completes
+// or ctx is cancelled. An error is returned when req itself is invalid, or
+// when the result log could not be written (errResultLog; stats are still
+// complete then).
+func PerformProbe(ctx context.Context, req ProbeRequest) (ProbeStats, error) {
+	run, err := newProbeRun(ctx, req)
+	if err != nil {
+		return ProbeStats{}, err
+	}
+	stats := run.Run(nil)
+	if run.log != nil {
+		if err := run.log.Flush(); err != nil {
+			return stats, fmt.Errorf("%w: %v", errResultLog, err)
+		}
+	}
+	return stats, nil
+}
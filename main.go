@@ -1,40 +1,385 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// exitThresholdsFailed is returned when the run completes but at least one
+// -threshold does not hold, so CI can tell it apart from an invalid run (1)
+// and a flag parse error (2).
+const exitThresholdsFailed = 3
+
+// errInterrupted is the cancel cause on SIGINT/SIGTERM; the partial report
+// is still written, then the process exits with exitInterrupted.
+var errInterrupted = errors.New("interrupted")
+
+const exitInterrupted = 130
+
+// exitRegression is returned by "compare" when the new run is worse than
+// the tolerances allow; 2 stays the flag parse error.
+const exitRegression = 4
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			os.Exit(reportCommand(os.Args[2:]))
+		case "compare":
+			os.Exit(compareCommand(os.Args[2:]))
+		}
+	}
+
 	targetURL := flag.String("u", "", "Target URL (e.g., http://example.com)")
 	concurrency := flag.Int("c", 10, "Concurrency level (number of workers)")
 	requestCount := flag.Int("n", 100, "Number of requests to send")
 	timeoutSec := flag.Int("t", 5, "Request timeout in seconds")
 	webMode := flag.Bool("web", false, "Start web dashboard")
 	port := flag.Int("port", 8080, "Port for web dashboard")
+	configPath := flag.String("config", os.Getenv("MECHANIC_CONFIG"), "Web mode config file (API tokens, quotas, CORS origins, target policy)")
+	method := flag.String("X", "", "HTTP method (default HEAD, or POST when a body is set)")
+	body := flag.String("body", "", "Request body: inline, @file, or @- for stdin")
+	var duration time.Duration
+	flag.DurationVar(&duration, "d", 0, "Run for this long (e.g. 30s, 5m) instead of a fixed request count")
+	flag.DurationVar(&duration, "duration", 0, "Alias for -d")
+	var headers headerFlags
+	flag.Var(&headers, "H", "Request header \"Name: value\" (repeatable)")
+	bucket := flag.Duration("bucket", defaultBucket, "Timeline bucket width in reports (e.g. 1s, 500ms)")
+	rate := flag.Float64("rate", 0, "Open-loop mode: send at a constant rate (requests/sec) regardless of response times")
+	stagesArg := flag.String("stages", "", "Load profile: \"60s:200,5m:200,30s:0\" (optionally name=60s:200) or @file.json")
+	stageMode := flag.String("stage-mode", StageModeRate, "Stage targets are \"rate\" (req/s) or \"concurrency\" (workers)")
+	scenarioPath := flag.String("scenario", "", "JSON scenario file with a weighted mix of requests")
+	checkStatus := flag.String("check-status", "", "Check: expected status codes (e.g. 200,204)")
+	checkBody := flag.String("check-body", "", "Check: body contains this text")
+	checkRegex := flag.String("check-regex", "", "Check: body matches this regular expression")
+	var checkJSON, checkHeaders listFlags
+	flag.Var(&checkJSON, "check-json", "Check: JSON path equals value, e.g. data.items[0].id=42 (repeatable)")
+	flag.Var(&checkHeaders, "check-header", "Check: response header is present (repeatable)")
+	checkMaxBody := flag.Int64("check-max-body", 0, "Check: body is at most this many bytes")
+	checkMaxLatency := flag.Duration("check-max-latency", 0, "Check: latency is at most this long (e.g. 300ms)")
+	var thresholds listFlags
+	flag.Var(&thresholds, "threshold", "Pass/fail gate, e.g. p95<300ms, error_rate<1%, rps>200 (repeatable or comma separated)")
+	abortOnFail := flag.Bool("abort-on-fail", false, "Stop the run as soon as a threshold can no longer pass")
+	successStatus := flag.String("success", DefaultSuccessStatus, "Status codes counted as success (e.g. 2xx, 200,204, 200-299)")
+	formatArg := flag.String("format", "", "Report format: text, json, csv or markdown (default text, or from the -o extension)")
+	outPath := flag.String("o", "", "Write the report to this file instead of stdout")
+	htmlPath := flag.String("html", "", "Also write a self-contained HTML report to this file")
+	redirect := flag.String("redirect", RedirectNone, "Redirects: none, follow or same-host")
+	maxRedirects := flag.Int("max-redirects", defaultMaxRedirects, "Max redirects followed per request")
+	proto := flag.String("proto", ProtocolAuto, "Protocol: auto, http1, http2 (TLS only) or h2c (cleartext HTTP/2, prior knowledge)")
+	noKeepAlive := flag.Bool("no-keepalive", false, "Open a new connection for every request")
+	maxConns := flag.Int("max-conns", 0, "Max connections per host (default unlimited)")
+	idleConns := flag.Int("idle-conns", 0, "Idle connection pool size per host (default 500)")
+	dialTimeout := flag.Duration("dial-timeout", 0, "TCP connect timeout (default 3s)")
+	tlsTimeout := flag.Duration("tls-timeout", 0, "TLS handshake timeout (default 3s)")
+	var resolve listFlags
+	flag.Var(&resolve, "resolve", "Pin host:port to an address, curl style: host:port:addr[,addr] (repeatable)")
+	dnsServer := flag.String("dns-server", "", "DNS server to resolve targets with, e.g. 1.1.1.1 or 10.0.0.2:53")
+	roundRobin := flag.Bool("round-robin", false, "Spread new connections over every A/AAAA record of the target (use -no-keepalive for every request)")
+	insecure := flag.Bool("k", false, "Skip TLS certificate verification")
+	caCert := flag.String("cacert", "", "PEM file of CA certificates to verify the target with")
+	clientCert := flag.String("cert", "", "Client certificate PEM file (mTLS)")
+	clientKey := flag.String("key", "", "Client private key PEM file (mTLS)")
+	sni := flag.String("sni", "", "TLS server name (SNI) override")
+	tlsMin := flag.String("tls-min", "", "Minimum TLS version: 1.0, 1.1, 1.2 (default) or 1.3")
+	tlsMax := flag.String("tls-max", "", "Maximum TLS version")
+	ciphers := flag.String("ciphers", "", "Comma separated TLS 1.0-1.2 cipher suites")
+	resultsPath := flag.String("results", "", "Log every request as newline-delimited JSON to this file (see \"report\" subcommand)")
 
 	flag.Parse()
 
 	if *webMode {
-		StartWebServer(*port)
+		cfg, err := LoadServerConfig(*configPath)
+		if err != nil {
+			fmt.Printf("[!] %v\n", err)
+			os.Exit(1)
+		}
+		StartWebServer(*port, cfg)
 		return
 	}
 
-	if *targetURL == "" {
-		fmt.Println("Usage: goprobe -u <target_url> [-c concurrency] [-n count] [-t timeout] [-web]")
+	if *targetURL == "" && *scenarioPath == "" {
+		fmt.Println("Usage: goprobe -u <target_url> | -scenario <file> [-c concurrency] [-n count] [-t timeout] [-d duration] [-X method] [-H header] [-body data] [-format fmt] [-o file] [-html file] [-web]")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	fmt.Printf("[*] Starting %d workers for target: %s\n", *concurrency, *targetURL)
-	stats := PerformProbe(*targetURL, *concurrency, *requestCount, *timeoutSec)
+	reqBody, err := LoadBody(*body)
+	if err != nil {
+		fmt.Printf("[!] Cannot read body: %v\n", err)
+		os.Exit(1)
+	}
+	var stages []Stage
+	if *stagesArg != "" {
+		if stages, err = ParseStages(*stagesArg); err != nil {
+			fmt.Printf("[!] %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var scenario []ScenarioRequest
+	tlsOpts := &TLSOptions{}
+	if *scenarioPath != "" {
+		sc, err := LoadScenario(*scenarioPath)
+		if err != nil {
+			fmt.Printf("[!] %v\n", err)
+			os.Exit(1)
+		}
+		scenario = sc.Requests
+		if sc.TLS != nil {
+			tlsOpts = sc.TLS
+		}
+	}
+	// TLS flags override the scenario file
+	if *insecure {
+		tlsOpts.Insecure = true
+	}
+	if *caCert != "" {
+		tlsOpts.CA = *caCert
+	}
+	if *clientCert != "" {
+		tlsOpts.Cert = *clientCert
+	}
+	if *clientKey != "" {
+		tlsOpts.Key = *clientKey
+	}
+	if *sni != "" {
+		tlsOpts.ServerName = *sni
+	}
+	if *tlsMin != "" {
+		tlsOpts.MinVersion = *tlsMin
+	}
+	if *tlsMax != "" {
+		tlsOpts.MaxVersion = *tlsMax
+	}
+	if *ciphers != "" {
+		tlsOpts.Ciphers = splitList(*ciphers)
+	}
+	checks := &Checks{
+		Status:        *checkStatus,
+		BodyContains:  *checkBody,
+		BodyRegex:     *checkRegex,
+		HeaderPresent: checkHeaders,
+		MaxBodyBytes:  *checkMaxBody,
+		MaxLatencyMs:  float64(*checkMaxLatency) / float64(time.Millisecond),
+	}
+	for _, arg := range checkJSON {
+		path, value, err := ParseJSONCheck(arg)
+		if err != nil {
+			fmt.Printf("[!] %v\n", err)
+			os.Exit(1)
+		}
+		if checks.JSONEquals == nil {
+			checks.JSONEquals = make(map[string]interface{})
+		}
+		checks.JSONEquals[path] = value
+	}
+	if checks.Empty() {
+		checks = nil
+	}
+	reqHeaders, err := headers.Map()
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		os.Exit(1)
+	}
+
+	format, out, err := openReport(*formatArg, *outPath)
+	if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		os.Exit(1)
+	}
+	var resultLog *os.File
+	if *resultsPath != "" {
+		if resultLog, err = os.Create(*resultsPath); err != nil {
+			fmt.Printf("[!] %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	target := *targetURL
+	if *scenarioPath != "" {
+		target = fmt.Sprintf("scenario %s (%d requests)", *scenarioPath, len(scenario))
+	}
+
+	// Machine-readable output on stdout must not be mixed with progress lines
+	log := os.Stdout
+	if out == nil && format != FormatText {
+		log = os.Stderr
+	}
+
+	probe := ProbeRequest{
+		URL:           *targetURL,
+		Concurrency:   *concurrency,
+		Count:         *requestCount,
+		Timeout:       *timeoutSec,
+		SuccessStatus: *successStatus,
+		Method:        *method,
+		Headers:       reqHeaders,
+		Body:          reqBody,
+		Rate:          *rate,
+		Duration:      duration.Seconds(),
+		Bucket:        bucket.Seconds(),
+		TLS:           tlsOpts,
+		Protocol:      *proto,
+		Redirect:      *redirect,
+		MaxRedirects:  *maxRedirects,
+		Stages:        stages,
+		StageMode:     *stageMode,
+		Scenario:      scenario,
+		Checks:        checks,
+		Thresholds:    thresholds,
+		AbortOnFail:   *abortOnFail,
+
+		DisableKeepAlive:    *noKeepAlive,
+		MaxConnsPerHost:     *maxConns,
+		IdleConns:           *idleConns,
+		DialTimeout:         dialTimeout.Seconds(),
+		TLSHandshakeTimeout: tlsTimeout.Seconds(),
+		Resolve:             resolve,
+		DNSServer:           *dnsServer,
+		RoundRobin:          *roundRobin,
+	}
+	if resultLog != nil {
+		probe.ResultLog = resultLog
+	}
+	fmt.Fprintf(log, "[*] Starting %d workers for target: %s\n", *concurrency, target)
+	started := time.Now()
+	// Ctrl-C stops the run early and still reports what was collected
+	ctx, cancel := context.WithCancelCause(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		fmt.Fprintf(log, "\n[!] Interrupted, stopping...\n")
+		cancel(errInterrupted)
+		signal.Stop(interrupt)
+	}()
+
+	stats, err := PerformProbe(ctx, probe)
+	signal.Stop(interrupt)
+	if errors.Is(err, errResultLog) {
+		// The stats are complete; still report them and apply thresholds
+		fmt.Fprintf(log, "[!] %v\n", err)
+	} else if err != nil {
+		fmt.Printf("[!] %v\n", err)
+		os.Exit(1)
+	}
+
+	if resultLog != nil {
+		if err := resultLog.Close(); err != nil {
+			fmt.Fprintf(log, "[!] Writing results: %v\n", err)
+		}
+	}
+
+	report := NewReport(target, probe, stats, started)
+	if err := emitReport(report, format, out); err != nil {
+		fmt.Fprintf(os.Stderr, "[!] Writing report: %v\n", err)
+		os.Exit(1)
+	}
+	if *htmlPath != "" {
+		if err := writeHTMLReport(report, *htmlPath); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] Writing HTML report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(log, "[*] HTML report written to %s\n", *htmlPath)
+	}
+
+	if errors.Is(context.Cause(ctx), errInterrupted) {
+		os.Exit(exitInterrupted)
+	}
+	if len(stats.Thresholds) > 0 && !stats.ThresholdsPassed {
+		fmt.Fprintf(log, "\n[!] Thresholds failed\n")
+		os.Exit(exitThresholdsFailed)
+	}
+}
+
+// openReport resolves the report format and creates the -o file, if any.
+func openReport(formatArg, path string) (string, *os.File, error) {
+	format, err := ReportFormat(formatArg, path)
+	if err != nil || path == "" {
+		return format, nil, err
+	}
+	out, err := os.Create(path)
+	return format, out, err
+}
+
+// emitReport writes report to out, or to stdout when out is nil. A report
+// written to a file is still summarised on the terminal.
+func emitReport(report *Report, format string, out *os.File) error {
+	if out == nil {
+		return report.Write(os.Stdout, format)
+	}
+	if err := report.Write(out, format); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	report.Write(os.Stdout, FormatText)
+	fmt.Printf("\n[*] Report written to %s\n", out.Name())
+	return nil
+}
+
+func writeHTMLReport(report *Report, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteHTML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// listFlags collects a repeatable string flag.
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlags) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(v string) error {
+	if _, _, err := ParseHeader(v); err != nil {
+		return err
+	}
+	*h = append(*h, v)
+	return nil
+}
 
-	fmt.Printf("\n--- Statistics for %s ---\n", stats.TargetURL)
-	fmt.Printf("Total Requests: %d\n", stats.TotalRequest)
-	fmt.Printf("Successful:     %d\n", stats.SuccessCount)
-	fmt.Printf("Failed:         %d\n", stats.ErrorCount)
-	if stats.SuccessCount > 0 {
-		fmt.Printf("Avg Latency:    %v\n", stats.AvgLatency)
+// Map merges the headers, joining repeated names with ", ".
+func (h headerFlags) Map() (map[string]string, error) {
+	m := make(map[string]string, len(h))
+	for _, raw := range h {
+		name, value, err := ParseHeader(raw)
+		if err != nil {
+			return nil, err
+		}
+		name = http.CanonicalHeaderKey(name)
+		if prev, ok := m[name]; ok {
+			value = prev + ", " + value
+		}
+		m[name] = value
 	}
+	return m, nil
 }
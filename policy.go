@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ownership proofs a target can publish for TargetPolicy.VerifyToken.
+const (
+	verifyPath      = "/.well-known/mechanic-verify.txt" // body contains the token
+	verifyTXTPrefix = "_mechanic-verify."                // TXT "mechanic-verify=<token>"
+	verifyTTL       = time.Hour                          // how long a proof is trusted
+)
+
+// TargetPolicy restricts what the web API may probe.
+type TargetPolicy struct {
+	// Exact hosts ("api.example.com"), domain suffixes (".example.com"
+	// or "*.example.com", which also match example.com itself) and CIDRs
+	// ("203.0.113.0/24", matched against IP literal hosts). Empty allows
+	// any target.
+	Allow []string `json:"allow"`
+
+	// Runs planning more requests than VerifyAbove need an ownership
+	// proof of VerifyToken from every host. 0 disables the check.
+	VerifyAbove int    `json:"verify_above"`
+	VerifyToken string `json:"verify_token"`
+	Resolver    string `json:"resolver"` // DNS server for TXT lookups, e.g. "1.1.1.1:53"
+}
+
+// policyError is a refused target; it is answered with 403.
+func policyError(format string, args ...interface{}) error {
+	return &apiError{Code: http.StatusForbidden, Msg: fmt.Sprintf(format, args...)}
+}
+
+// validate checks the policy once at startup.
+func (p *TargetPolicy) validate() error {
+	for _, entry := range p.Allow {
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("target allowlist: %v", err)
+			}
+		}
+	}
+	if p.VerifyAbove > 0 && p.VerifyToken == "" {
+		return fmt.Errorf("target policy: verify_above needs a verify_token")
+	}
+	if p.Resolver != "" {
+		if _, _, err := net.SplitHostPort(p.Resolver); err != nil {
+			return fmt.Errorf("target policy resolver: %v", err)
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether host matches the allowlist.
+func (p *TargetPolicy) Allowed(host string) bool {
+	if len(p.Allow) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, entry := range p.Allow {
+		entry = strings.ToLower(entry)
+		switch {
+		case strings.Contains(entry, "/"):
+			_, cidr, err := net.ParseCIDR(entry)
+			if err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		case strings.HasPrefix(entry, "*.") || strings.HasPrefix(entry, "."):
+			base := strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+			if host == base || strings.HasSuffix(host, "."+base) {
+				return true
+			}
+		default:
+			if host == entry {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check refuses req if any target is outside the allowlist, or if the run is
+// above the verification budget and a host has no ownership proof.
+func (p *TargetPolicy) Check(ctx context.Context, req *ProbeRequest) error {
+	mix, err := newRequestMix(*req)
+	if err != nil {
+		return err
+	}
+	var targets []*url.URL
+	seen := make(map[string]bool)
+	for _, spec := range mix.specs {
+		u, err := url.Parse(spec.URL)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Errorf("invalid target URL %q", spec.URL)
+		}
+		if !p.Allowed(u.Hostname()) {
+			return policyError("Target %s is not in the allowlist", u.Hostname())
+		}
+		if !seen[u.Host] {
+			seen[u.Host] = true
+			targets = append(targets, u)
+		}
+	}
+
+	if p.VerifyAbove <= 0 {
+		return nil
+	}
+	planned, ok := plannedRequests(req)
+	if req.MaxRequests > 0 && (!ok || planned > req.MaxRequests) {
+		planned, ok = req.MaxRequests, true
+	}
+	if ok && planned <= p.VerifyAbove {
+		return nil
+	}
+	for _, u := range targets {
+		if !ownership.Verified(ctx, p, u, req.Guard) {
+			return policyError("Ownership of %s is not verified: serve the verify token at %s or in a TXT record at %s (runs up to %d requests need no proof)",
+				u.Host, verifyPath, verifyTXTPrefix+u.Hostname(), p.VerifyAbove)
+		}
+	}
+	return nil
+}
+
+// ownershipCache remembers hosts that proved ownership recently.
+type ownershipCache struct {
+	mu       sync.Mutex
+	verified map[string]time.Time
+}
+
+var ownership = &ownershipCache{verified: make(map[string]time.Time)}
+
+// Verified checks the well-known path first, then DNS.
+func (c *ownershipCache) Verified(ctx context.Context, p *TargetPolicy, u *url.URL, guard *AddrGuard) bool {
+	key := u.Scheme + "://" + u.Host
+	c.mu.Lock()
+	at, ok := c.verified[key]
+	c.mu.Unlock()
+	if ok && time.Since(at) < verifyTTL {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !verifyHTTP(ctx, p.VerifyToken, u, guard) && !verifyTXT(ctx, p, u.Hostname()) {
+		return false
+	}
+	fmt.Printf("[AUTH] Ownership of %s verified\n", key)
+	c.mu.Lock()
+	c.verified[key] = time.Now()
+	c.mu.Unlock()
+	return true
+}
+
+func verifyHTTP(ctx context.Context, token string, u *url.URL, guard *AddrGuard) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.Scheme+"://"+u.Host+verifyPath, nil)
+	if err != nil {
+		return false
+	}
+	client, err := NewClient(ProbeRequest{Timeout: 5, Guard: guard})
+	if err != nil {
+		return false
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return err == nil && strings.Contains(string(body), token)
+}
+
+func verifyTXT(ctx context.Context, p *TargetPolicy, host string) bool {
+	if net.ParseIP(host) != nil {
+		return false
+	}
+	resolver := net.DefaultResolver
+	if p.Resolver != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, p.Resolver)
+			},
+		}
+	}
+	records, err := resolver.LookupTXT(ctx, verifyTXTPrefix+host)
+	if err != nil {
+		return false
+	}
+	for _, r := range records {
+		if strings.TrimSpace(r) == "mechanic-verify="+p.VerifyToken {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestTargetPolicyAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		host  string
+		want  bool
+	}{
+		{"empty allows any", nil, "example.org", true},
+		{"exact", []string{"api.example.com"}, "api.example.com", true},
+		{"exact is case-insensitive", []string{"API.example.com"}, "api.EXAMPLE.com", true},
+		{"exact with trailing dot", []string{"api.example.com"}, "api.example.com.", true},
+		{"exact does not match subdomain", []string{"api.example.com"}, "x.api.example.com", false},
+		{"exact does not match parent", []string{"api.example.com"}, "example.com", false},
+		{"wildcard subdomain", []string{"*.example.com"}, "api.example.com", true},
+		{"wildcard deep subdomain", []string{"*.example.com"}, "a.b.example.com", true},
+		{"wildcard bare domain", []string{"*.example.com"}, "example.com", true},
+		{"wildcard trailing dot", []string{"*.example.com"}, "api.example.com.", true},
+		{"wildcard is not a substring match", []string{"*.example.com"}, "badexample.com", false},
+		{"wildcard other domain", []string{"*.example.com"}, "example.com.evil.net", false},
+		{"dot suffix subdomain", []string{".example.com"}, "api.example.com", true},
+		{"dot suffix bare domain", []string{".example.com"}, "example.com", true},
+		{"dot suffix not a substring match", []string{".example.com"}, "notexample.com", false},
+		{"cidr", []string{"203.0.113.0/24"}, "203.0.113.7", true},
+		{"cidr outside", []string{"203.0.113.0/24"}, "203.0.114.1", false},
+		{"cidr ignores names", []string{"203.0.113.0/24"}, "example.com", false},
+		{"cidr v6", []string{"2001:db8::/32"}, "2001:db8::1", true},
+		{"ip literal needs a cidr", []string{"example.com"}, "203.0.113.7", false},
+		{"any entry matches", []string{"example.org", "*.example.com"}, "api.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := TargetPolicy{Allow: tt.allow}
+			if got := p.Allowed(tt.host); got != tt.want {
+				t.Errorf("Allowed(%q) with %v = %v, want %v", tt.host, tt.allow, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TargetPolicy
+		ok     bool
+	}{
+		{"empty", TargetPolicy{}, true},
+		{"hosts and cidrs", TargetPolicy{Allow: []string{"example.com", "10.0.0.0/8"}}, true},
+		{"bad cidr", TargetPolicy{Allow: []string{"10.0.0.0/33"}}, false},
+		{"verify without token", TargetPolicy{VerifyAbove: 100}, false},
+		{"verify with token", TargetPolicy{VerifyAbove: 100, VerifyToken: "t"}, true},
+		{"resolver without port", TargetPolicy{Resolver: "1.1.1.1"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestTargetPolicyCheck(t *testing.T) {
+	p := TargetPolicy{Allow: []string{"*.example.com"}}
+	tests := []struct {
+		name string
+		req  ProbeRequest
+		ok   bool
+	}{
+		{"allowed", ProbeRequest{URL: "https://api.example.com/health"}, true},
+		{"refused", ProbeRequest{URL: "https://example.org/"}, false},
+		{"scenario entry refused", ProbeRequest{URL: "https://api.example.com", Scenario: []ScenarioRequest{
+			{URL: "/a"},
+			{URL: "https://example.org/b"},
+		}}, false},
+	}
+	for _, tt := range tests {
+		err := p.Check(context.Background(), &tt.req)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: Check() = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+			t.Errorf("%s: Check() = %v, want a 403", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportSchemaVersion is bumped whenever a field of Report (or of the CSV
+// and Markdown layouts) is renamed, removed or changes meaning. Adding
+// fields does not bump it.
+const ReportSchemaVersion = 1
+
+// Report formats accepted by -format.
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+)
+
+// Report is the machine-readable record of a run.
+//
+// JSON schema (version 1): every time.Duration is an integer number of
+// nanoseconds, rates are fractions, throughput is requests per second and
+// timestamps are RFC 3339. Stats is ProbeStats as served by /api/probe.
+//
+// CSV schema (version 1): header "section,name,metric,value", one value per
+// row. Sections are meta, config, total, latency, first_hop, phase, status,
+// error_class, tls, timeline, stage, request, protocol, addr, check and
+// threshold; latencies are in milliseconds and timeline names are bucket
+// offsets in seconds.
+type Report struct {
+	Schema     int          `json:"schema"`
+	Tool       string       `json:"tool"`
+	GoVersion  string       `json:"go_version"`
+	Target     string       `json:"target"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Config     ProbeRequest `json:"config"`
+	Stats      ProbeStats   `json:"stats"`
+}
+
+// redactedHeaders never leave the process in a report or a job view.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+func NewReport(target string, req ProbeRequest, stats ProbeStats, started time.Time) *Report {
+	req = redactRequest(req)
+	return &Report{
+		Schema:     ReportSchemaVersion,
+		Tool:       "mechanic",
+		GoVersion:  runtime.Version(),
+		Target:     target,
+		StartedAt:  started.UTC(),
+		FinishedAt: started.Add(stats.Elapsed).UTC(),
+		Config:     req,
+		Stats:      stats,
+	}
+}
+
+// LoadReport reads a report saved with -format json.
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: invalid report: %v", path, err)
+	}
+	if r.Schema == 0 || r.Schema > ReportSchemaVersion {
+		return nil, fmt.Errorf("%s: unsupported report schema %d", path, r.Schema)
+	}
+	return &r, nil
+}
+
+// redactRequest returns a copy of req safe to echo back: credentials in
+// headers and an inline TLS key are replaced.
+func redactRequest(req ProbeRequest) ProbeRequest {
+	req.Headers = redactHeaders(req.Headers)
+	req.TLS = req.TLS.redacted()
+	if len(req.Scenario) > 0 {
+		scenario := make([]ScenarioRequest, len(req.Scenario))
+		for i, sr := range req.Scenario {
+			sr.Headers = redactHeaders(sr.Headers)
+			scenario[i] = sr
+		}
+		req.Scenario = scenario
+	}
+	return req
+}
+
+func redactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		for _, h := range redactedHeaders {
+			if http.CanonicalHeaderKey(k) == h {
+				v = "REDACTED"
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// ReportFormat resolves -format, falling back to the -o extension.
+func ReportFormat(format, path string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case "":
+			return FormatText, nil
+		case ".csv":
+			return FormatCSV, nil
+		case ".md", ".markdown":
+			return FormatMarkdown, nil
+		case ".txt":
+			return FormatText, nil
+		case ".json":
+			return FormatJSON, nil
+		case ".html", ".htm":
+			return "", fmt.Errorf("unknown format for extension %q (use -html for an HTML report, or set -format)", filepath.Ext(path))
+		default:
+			return "", fmt.Errorf("unknown format for extension %q (set -format, or use .txt, .json, .csv or .md)", filepath.Ext(path))
+		}
+	}
+	switch strings.ToLower(format) {
+	case FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatMarkdown, "md":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown format %q (expected text, json, csv or markdown)", format)
+}
+
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(r)
+	case FormatCSV:
+		return r.writeCSV(w)
+	case FormatMarkdown:
+		return r.writeMarkdown(w)
+	default:
+		r.writeText(w)
+		return nil
+	}
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(durationMs(d), 'f', 3, 64)
+}
+
+func sortedCodes(m map[int]int) []int {
+	codes := make([]int, 0, len(m))
+	for code := range m {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func errorRate(stats ProbeStats) float64 {
+	if stats.TotalRequest == 0 {
+		return 0
+	}
+	return float64(stats.ErrorCount) / float64(stats.TotalRequest)
+}
+
+// writeText is the human summary printed by the CLI.
+func (r *Report) writeText(w io.Writer) {
+	stats := r.Stats
+	fmt.Fprintf(w, "\n--- Statistics for %s ---\n", r.Target)
+	if stats.Aborted != "" {
+		fmt.Fprintf(w, "Aborted:        %s\n", stats.Aborted)
+	}
+	fmt.Fprintf(w, "Total Requests: %d\n", stats.TotalRequest)
+	fmt.Fprintf(w, "Successful:     %d\n", stats.SuccessCount)
+	fmt.Fprintf(w, "Failed:         %d\n", stats.ErrorCount)
+	if len(stats.Checks) > 0 {
+		fmt.Fprintf(w, "Failed Checks:  %d\n", stats.ChecksFailed)
+	}
+	fmt.Fprintf(w, "Elapsed:        %v\n", stats.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput:     %.1f req/s\n", stats.Throughput)
+	if stats.Rate > 0 {
+		fmt.Fprintf(w, "Target Rate:    %.1f req/s\n", stats.Rate)
+	}
+	if stats.Rate > 0 || (len(r.Config.Stages) > 0 && r.Config.StageMode == StageModeRate) {
+		fmt.Fprintf(w, "Delayed:        %d (no idle worker at scheduled time)\n", stats.Delayed)
+		fmt.Fprintf(w, "Dropped:        %d (not sent within timeout of schedule)\n", stats.Dropped)
+	}
+	if stats.Latency.Count > 0 {
+		lat := stats.Latency
+		fmt.Fprintf(w, "Avg Latency:    %v\n", stats.AvgLatency)
+		fmt.Fprintf(w, "Min / Max:      %v / %v\n", lat.Min, lat.Max)
+		fmt.Fprintf(w, "Std Dev:        %v\n", lat.StdDev)
+		fmt.Fprintf(w, "\nLatency Percentiles:\n")
+		fmt.Fprintf(w, "  p50:   %v\n", lat.P50)
+		fmt.Fprintf(w, "  p90:   %v\n", lat.P90)
+		fmt.Fprintf(w, "  p95:   %v\n", lat.P95)
+		fmt.Fprintf(w, "  p99:   %v\n", lat.P99)
+		fmt.Fprintf(w, "  p99.9: %v\n", lat.P999)
+		if fh := stats.FirstHop; fh != nil {
+			fmt.Fprintf(w, "\nFirst Hop (%d redirected):\n", stats.Redirected)
+			fmt.Fprintf(w, "  mean / p50 / p99: %v / %v / %v\n", fh.Mean, fh.P50, fh.P99)
+		}
+
+		ph := stats.Phases
+		fmt.Fprintf(w, "\nPhases (mean / p99):\n")
+		for _, p := range phaseRows(ph) {
+			fmt.Fprintf(w, "  %-9s %v / %v\n", p.Name+":", p.Summary.Mean, p.Summary.P99)
+		}
+		fmt.Fprintf(w, "  Connections: %d new / %d reused\n", ph.NewConns, ph.ReusedConns)
+		if ph.NewConns > 0 && ph.ReusedConns > 0 {
+			fmt.Fprintf(w, "  TTFB new / reused conn: %v / %v\n", ph.NewConnTTFB.Mean, ph.ReusedConnTTFB.Mean)
+		}
+	}
+
+	if len(stats.Stages) > 0 {
+		fmt.Fprintf(w, "\nStages:\n")
+		writeBreakdown(w, stats.Stages)
+	}
+	if len(stats.Requests) > 0 {
+		fmt.Fprintf(w, "\nRequests:\n")
+		writeBreakdown(w, stats.Requests)
+	}
+	if len(stats.Protocols) > 0 {
+		fmt.Fprintf(w, "\nProtocols:\n")
+		writeBreakdown(w, stats.Protocols)
+	}
+	if len(stats.Addrs) > 0 {
+		fmt.Fprintf(w, "\nAddresses:\n")
+		writeBreakdown(w, stats.Addrs)
+	}
+	if len(stats.Checks) > 0 {
+		fmt.Fprintf(w, "\nChecks:\n")
+		for _, c := range stats.Checks {
+			mark := "PASS"
+			if c.Fails > 0 {
+				mark = "FAIL"
+			}
+			fmt.Fprintf(w, "  [%s] %s: %d passed, %d failed\n", mark, c.Name, c.Passes, c.Fails)
+			for _, sample := range c.Samples {
+				fmt.Fprintf(w, "         %s -> %d: %s\n", sample.URL, sample.StatusCode, sample.Detail)
+				if sample.Body != "" {
+					fmt.Fprintf(w, "         body: %q\n", sample.Body)
+				}
+			}
+		}
+	}
+	if len(stats.StatusCodes) > 0 {
+		fmt.Fprintf(w, "\nStatus Codes:\n")
+		for _, code := range sortedCodes(stats.StatusCodes) {
+			fmt.Fprintf(w, "  %d: %d\n", code, stats.StatusCodes[code])
+		}
+	}
+	if len(stats.ErrorClasses) > 0 {
+		fmt.Fprintf(w, "\nTransport Errors:\n")
+		for _, class := range sortedKeys(stats.ErrorClasses) {
+			fmt.Fprintf(w, "  %s: %d\n", class, stats.ErrorClasses[class])
+		}
+	}
+	if len(stats.TLS) > 0 {
+		fmt.Fprintf(w, "\nTLS:\n")
+		for _, label := range sortedKeys(stats.TLS) {
+			fmt.Fprintf(w, "  %s: %d\n", label, stats.TLS[label])
+		}
+	}
+
+	if len(stats.Thresholds) > 0 {
+		fmt.Fprintf(w, "\nThresholds:\n")
+		for _, t := range stats.Thresholds {
+			mark := "PASS"
+			if !t.OK {
+				mark = "FAIL"
+			}
+			fmt.Fprintf(w, "  [%s] %s (actual: %.4g)\n", mark, t.Expr, t.Actual)
+		}
+	}
+}
+
+type phaseRow struct {
+	Name    string
+	Summary LatencySummary
+}
+
+func phaseRows(ph PhaseStats) []phaseRow {
+	return []phaseRow{
+		{"DNS", ph.DNS}, {"Connect", ph.Connect}, {"TLS", ph.TLS},
+		{"Server", ph.Server}, {"TTFB", ph.TTFB}, {"Transfer", ph.Transfer},
+	}
+}
+
+// writeBreakdown prints one row per stage or scenario request.
+func writeBreakdown(w io.Writer, rows []ProbeStats) {
+	width := 12
+	for _, st := range rows {
+		if len(st.Name) > width {
+			width = len(st.Name)
+		}
+	}
+	fmt.Fprintf(w, "  %-*s %8s %8s %10s %8s %12s %12s\n", width, "NAME", "TIME", "REQS", "REQ/S", "ERRORS", "P50", "P99")
+	for _, st := range rows {
+		fmt.Fprintf(w, "  %-*s %8v %8d %10.1f %8d %12v %12v\n", width,
+			st.Name, st.Elapsed.Round(time.Millisecond), st.TotalRequest, st.Throughput, st.ErrorCount, st.Latency.P50, st.Latency.P99)
+	}
+}
+
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	row := func(section, name, metric, value string) {
+		cw.Write([]string{section, name, metric, value})
+	}
+	summary := func(section, name string, st ProbeStats) {
+		row(section, name, "requests", strconv.Itoa(st.TotalRequest))
+		row(section, name, "success", strconv.Itoa(st.SuccessCount))
+		row(section, name, "errors", strconv.Itoa(st.ErrorCount))
+		row(section, name, "error_rate", strconv.FormatFloat(errorRate(st), 'f', 6, 64))
+		row(section, name, "rps", strconv.FormatFloat(st.Throughput, 'f', 3, 64))
+		row(section, name, "elapsed_ms", ms(st.Elapsed))
+		row(section, name, "p50_ms", ms(st.Latency.P50))
+		row(section, name, "p99_ms", ms(st.Latency.P99))
+	}
+
+	stats := r.Stats
+	row("section", "name", "metric", "value")
+	row("meta", "", "schema", strconv.Itoa(r.Schema))
+	row("meta", "", "tool", r.Tool)
+	row("meta", "", "go_version", r.GoVersion)
+	row("meta", "", "target", r.Target)
+	row("meta", "", "started_at", r.StartedAt.Format(time.RFC3339Nano))
+	row("meta", "", "finished_at", r.FinishedAt.Format(time.RFC3339Nano))
+
+	cfg := r.Config
+	row("config", "", "url", cfg.URL)
+	row("config", "", "method", cfg.Method)
+	row("config", "", "concurrency", strconv.Itoa(cfg.Concurrency))
+	row("config", "", "count", strconv.Itoa(cfg.Count))
+	row("config", "", "timeout_s", strconv.Itoa(cfg.Timeout))
+	row("config", "", "rate", strconv.FormatFloat(cfg.Rate, 'f', -1, 64))
+	row("config", "", "duration_s", strconv.FormatFloat(cfg.Duration, 'f', -1, 64))
+	row("config", "", "success_status", cfg.SuccessStatus)
+	row("config", "", "bucket_s", strconv.FormatFloat(bucketWidth(cfg.Bucket).Seconds(), 'f', -1, 64))
+
+	summary("total", "", stats)
+	row("total", "", "checks_failed", strconv.Itoa(stats.ChecksFailed))
+	row("total", "", "delayed", strconv.Itoa(stats.Delayed))
+	row("total", "", "dropped", strconv.Itoa(stats.Dropped))
+	row("total", "", "aborted", stats.Aborted)
+
+	latency := func(section string, lat LatencySummary) {
+		for _, m := range []struct {
+			name string
+			d    time.Duration
+		}{
+			{"min", lat.Min}, {"mean", lat.Mean}, {"stddev", lat.StdDev}, {"p50", lat.P50}, {"p90", lat.P90},
+			{"p95", lat.P95}, {"p99", lat.P99}, {"p99_9", lat.P999}, {"max", lat.Max},
+		} {
+			row(section, "", m.name+"_ms", ms(m.d))
+		}
+	}
+	latency("latency", stats.Latency)
+	if stats.FirstHop != nil {
+		row("total", "", "redirected", strconv.Itoa(stats.Redirected))
+		latency("first_hop", *stats.FirstHop)
+	}
+	for _, p := range phaseRows(stats.Phases) {
+		name := strings.ToLower(p.Name)
+		row("phase", name, "mean_ms", ms(p.Summary.Mean))
+		row("phase", name, "p99_ms", ms(p.Summary.P99))
+	}
+	row("phase", "connections", "new", strconv.Itoa(stats.Phases.NewConns))
+	row("phase", "connections", "reused", strconv.Itoa(stats.Phases.ReusedConns))
+	row("phase", "connections", "new_ttfb_mean_ms", ms(stats.Phases.NewConnTTFB.Mean))
+	row("phase", "connections", "reused_ttfb_mean_ms", ms(stats.Phases.ReusedConnTTFB.Mean))
+
+	for _, code := range sortedCodes(stats.StatusCodes) {
+		row("status", strconv.Itoa(code), "count", strconv.Itoa(stats.StatusCodes[code]))
+	}
+	for _, class := range sortedKeys(stats.ErrorClasses) {
+		row("error_class", class, "count", strconv.Itoa(stats.ErrorClasses[class]))
+	}
+	for _, label := range sortedKeys(stats.TLS) {
+		row("tls", label, "count", strconv.Itoa(stats.TLS[label]))
+	}
+	for _, b := range stats.Timeline {
+		at := strconv.FormatFloat(b.Start.Seconds(), 'f', -1, 64)
+		row("timeline", at, "requests", strconv.Itoa(b.Requests))
+		row("timeline", at, "errors", strconv.Itoa(b.Errors))
+		row("timeline", at, "rps", strconv.FormatFloat(b.RPS, 'f', 3, 64))
+		row("timeline", at, "p50_ms", ms(b.P50))
+		row("timeline", at, "p99_ms", ms(b.P99))
+	}
+	for _, st := range stats.Stages {
+		summary("stage", st.Name, st)
+	}
+	for _, st := range stats.Requests {
+		summary("request", st.Name, st)
+	}
+	for _, st := range stats.Protocols {
+		summary("protocol", st.Name, st)
+	}
+	for _, st := range stats.Addrs {
+		summary("addr", st.Name, st)
+	}
+	for _, c := range stats.Checks {
+		row("check", c.Name, "passes", strconv.Itoa(c.Passes))
+		row("check", c.Name, "fails", strconv.Itoa(c.Fails))
+	}
+	for _, t := range stats.Thresholds {
+		row("threshold", t.Expr, "actual", strconv.FormatFloat(t.Actual, 'f', -1, 64))
+		row("threshold", t.Expr, "ok", strconv.FormatBool(t.OK))
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r *Report) writeMarkdown(w io.Writer) error {
+	stats := r.Stats
+	mdEscape := strings.NewReplacer("|", "\\|", "\n", " ")
+
+	fmt.Fprintf(w, "# Probe report: %s\n\n", mdEscape.Replace(r.Target))
+	fmt.Fprintf(w, "| Run | |\n|---|---|\n")
+	fmt.Fprintf(w, "| Started | %s |\n", r.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "| Finished | %s |\n", r.FinishedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "| Go version | %s |\n", r.GoVersion)
+	fmt.Fprintf(w, "| Concurrency | %d |\n", r.Config.Concurrency)
+	if r.Config.Rate > 0 {
+		fmt.Fprintf(w, "| Rate | %g req/s |\n", r.Config.Rate)
+	}
+	if r.Config.Duration > 0 {
+		fmt.Fprintf(w, "| Duration | %gs |\n", r.Config.Duration)
+	} else if len(r.Config.Stages) == 0 {
+		fmt.Fprintf(w, "| Count | %d |\n", r.Config.Count)
+	}
+	if stats.Aborted != "" {
+		fmt.Fprintf(w, "| Aborted | %s |\n", mdEscape.Replace(stats.Aborted))
+	}
+
+	fmt.Fprintf(w, "\n## Summary\n\n| Metric | Value |\n|---|---|\n")
+	fmt.Fprintf(w, "| Requests | %d |\n", stats.TotalRequest)
+	fmt.Fprintf(w, "| Successful | %d |\n", stats.SuccessCount)
+	fmt.Fprintf(w, "| Failed | %d |\n", stats.ErrorCount)
+	fmt.Fprintf(w, "| Error rate | %.2f%% |\n", errorRate(stats)*100)
+	fmt.Fprintf(w, "| Throughput | %.1f req/s |\n", stats.Throughput)
+	fmt.Fprintf(w, "| Elapsed | %v |\n", stats.Elapsed.Round(time.Millisecond))
+
+	lat := stats.Latency
+	fmt.Fprintf(w, "\n## Latency (ms)\n\n| min | mean | p50 | p90 | p95 | p99 | p99.9 | max |\n|---|---|---|---|---|---|---|---|\n")
+	fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s | %s |\n",
+		ms(lat.Min), ms(lat.Mean), ms(lat.P50), ms(lat.P90), ms(lat.P95), ms(lat.P99), ms(lat.P999), ms(lat.Max))
+	if fh := stats.FirstHop; fh != nil {
+		fmt.Fprintf(w, "\n## First hop latency (ms)\n\n%d responses were redirected; the table above covers the full chain.\n\n", stats.Redirected)
+		fmt.Fprintf(w, "| min | mean | p50 | p90 | p95 | p99 | p99.9 | max |\n|---|---|---|---|---|---|---|---|\n")
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s | %s |\n",
+			ms(fh.Min), ms(fh.Mean), ms(fh.P50), ms(fh.P90), ms(fh.P95), ms(fh.P99), ms(fh.P999), ms(fh.Max))
+	}
+
+	if len(stats.StatusCodes) > 0 || len(stats.ErrorClasses) > 0 {
+		fmt.Fprintf(w, "\n## Responses\n\n| Status / error | Count |\n|---|---|\n")
+		for _, code := range sortedCodes(stats.StatusCodes) {
+			fmt.Fprintf(w, "| %d | %d |\n", code, stats.StatusCodes[code])
+		}
+		for _, class := range sortedKeys(stats.ErrorClasses) {
+			fmt.Fprintf(w, "| %s | %d |\n", class, stats.ErrorClasses[class])
+		}
+	}
+	if len(stats.TLS) > 0 {
+		fmt.Fprintf(w, "\n## TLS\n\n| Version / cipher | Responses |\n|---|---|\n")
+		for _, label := range sortedKeys(stats.TLS) {
+			fmt.Fprintf(w, "| %s | %d |\n", label, stats.TLS[label])
+		}
+	}
+
+	breakdown := func(title string, rows []ProbeStats) {
+		if len(rows) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n## %s\n\n| Name | Requests | req/s | Errors | p50 (ms) | p99 (ms) |\n|---|---|---|---|---|---|\n", title)
+		for _, st := range rows {
+			fmt.Fprintf(w, "| %s | %d | %.1f | %d | %s | %s |\n",
+				mdEscape.Replace(st.Name), st.TotalRequest, st.Throughput, st.ErrorCount, ms(st.Latency.P50), ms(st.Latency.P99))
+		}
+	}
+	if len(stats.Timeline) > 0 {
+		fmt.Fprintf(w, "\n## Timeline\n\n| Start (s) | Requests | req/s | Errors | p50 (ms) | p99 (ms) |\n|---|---|---|---|---|---|\n")
+		for _, b := range stats.Timeline {
+			fmt.Fprintf(w, "| %g | %d | %.1f | %d | %s | %s |\n", b.Start.Seconds(), b.Requests, b.RPS, b.Errors, ms(b.P50), ms(b.P99))
+		}
+	}
+
+	breakdown("Stages", stats.Stages)
+	breakdown("Requests", stats.Requests)
+	breakdown("Protocols", stats.Protocols)
+	breakdown("Addresses", stats.Addrs)
+
+	if len(stats.Checks) > 0 {
+		fmt.Fprintf(w, "\n## Checks\n\n| Check | Passes | Fails |\n|---|---|---|\n")
+		for _, c := range stats.Checks {
+			fmt.Fprintf(w, "| %s | %d | %d |\n", mdEscape.Replace(c.Name), c.Passes, c.Fails)
+		}
+	}
+	if len(stats.Thresholds) > 0 {
+		fmt.Fprintf(w, "\n## Thresholds\n\n| Threshold | Actual | Result |\n|---|---|---|\n")
+		for _, t := range stats.Thresholds {
+			result := "PASS"
+			if !t.OK {
+				result = "FAIL"
+			}
+			fmt.Fprintf(w, "| `%s` | %.4g | %s |\n", t.Expr, t.Actual, result)
+		}
+	}
+	return nil
+}
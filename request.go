@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RequestSpec is the prepared form of the request a Worker sends. It is
+// built once per run and shared read-only by every worker.
+type RequestSpec struct {
+	Name   string // scenario request name, empty for single-URL runs
+	Method string
+	URL    string
+	Host   string
+	Header http.Header
+	Body   []byte
+	Checks *checkSet // nil when no check is configured
+}
+
+// NewRequestSpec validates the method, headers and body of req.
+func NewRequestSpec(req ProbeRequest) (*RequestSpec, error) {
+	return newRequestSpec("", req.Method, req.URL, req.Headers, req.Body, req.Checks)
+}
+
+func newRequestSpec(name, method, url string, headers map[string]string, body string, checks *Checks) (*RequestSpec, error) {
+	compiled, err := compileChecks(checks, name)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &RequestSpec{
+		Name:   name,
+		Method: strings.ToUpper(strings.TrimSpace(method)),
+		URL:    normalizeURL(url),
+		Header: make(http.Header),
+		Body:   []byte(body),
+		Checks: compiled,
+	}
+
+	if spec.Method == "" {
+		spec.Method = "HEAD" // HEAD is faster than GET
+		if len(spec.Body) > 0 {
+			spec.Method = "POST"
+		} else if compiled != nil {
+			spec.Method = "GET" // checks need a real response body
+		}
+	}
+	if !validMethod(spec.Method) {
+		return nil, fmt.Errorf("invalid method %q", method)
+	}
+
+	for key, value := range headers {
+		key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+		switch key {
+		case "":
+			return nil, fmt.Errorf("empty header name")
+		case "Host":
+			spec.Host = value
+		case "Content-Length":
+			// Always derived from the body
+		default:
+			spec.Header.Set(key, value)
+		}
+	}
+
+	// Minimal headers for speed. Connection is left to the transport, which
+	// keeps connections alive unless DisableKeepAlive is set
+	if spec.Header.Get("User-Agent") == "" {
+		spec.Header.Set("User-Agent", "Mozilla/5.0")
+	}
+
+	if len(spec.Body) > 0 && spec.Header.Get("Content-Type") == "" {
+		if json.Valid(spec.Body) {
+			spec.Header.Set("Content-Type", "application/json")
+		} else {
+			spec.Header.Set("Content-Type", http.DetectContentType(spec.Body))
+		}
+	}
+
+	return spec, nil
+}
+
+// NewRequest builds an *http.Request from the spec. The body is wrapped in a
+// fresh reader so the same bytes are shared across requests without copying.
+func (s *RequestSpec) NewRequest(ctx context.Context) (*http.Request, error) {
+	var body io.Reader
+	if len(s.Body) > 0 {
+		body = bytes.NewReader(s.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, s.Method, s.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = s.Header.Clone()
+	if s.Host != "" {
+		req.Host = s.Host
+	}
+	return req, nil
+}
+
+// normalizeURL adds http:// if missing
+func normalizeURL(url string) string {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "http://" + url
+	}
+	return url
+}
+
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, c := range method {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseHeader splits a curl-style "Name: value" header.
+func ParseHeader(h string) (string, string, error) {
+	name, value, ok := strings.Cut(h, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return "", "", fmt.Errorf("invalid header %q (expected \"Name: value\")", h)
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(value), nil
+}
+
+// LoadBody resolves a CLI body argument: "@-" reads stdin, "@path" reads a
+// file and anything else is used as-is.
+func LoadBody(arg string) (string, error) {
+	if !strings.HasPrefix(arg, "@") {
+		return arg, nil
+	}
+	path := arg[1:]
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		return string(data), err
+	}
+	data, err := os.ReadFile(path)
+	return string(data), err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// parseResolve parses curl-style "host:port:addr[,addr...]" overrides into
+// "host:port" -> addresses. IPv6 addresses may be bracketed.
+func parseResolve(entries []string) (map[string][]string, error) {
+	pins := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid resolve %q (expected host:port:addr)", entry)
+		}
+		if _, err := strconv.ParseUint(parts[1], 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid resolve %q: bad port", entry)
+		}
+		key := net.JoinHostPort(strings.ToLower(parts[0]), parts[1])
+		for _, addr := range strings.Split(parts[2], ",") {
+			addr = strings.Trim(strings.TrimSpace(addr), "[]")
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("invalid resolve %q: %q is not an IP address", entry, addr)
+			}
+			pins[key] = append(pins[key], addr)
+		}
+	}
+	return pins, nil
+}
+
+// newResolver returns a resolver that asks server ("ip" or "ip:port")
+// instead of the system's. A non-nil guard also covers the DNS server,
+// so web mode cannot use it to reach an internal address.
+func newResolver(server string, guard *AddrGuard) *net.Resolver {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			if guard != nil {
+				d.Control = guard.Control
+			}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// resolvingDialer dials pinned addresses (--resolve) and, in round-robin
+// mode, rotates new connections over every A/AAAA record of the host.
+// With keep-alive the rotation is per connection, not per request.
+type resolvingDialer struct {
+	dialer     *net.Dialer
+	pins       map[string][]string
+	roundRobin bool
+	next       atomic.Uint64
+}
+
+// dialContext builds the transport's DialContext for req; it is the plain
+// dialer unless req pins or resolves addresses itself.
+func dialContext(dialer *net.Dialer, req ProbeRequest) (func(context.Context, string, string) (net.Conn, error), error) {
+	if req.DNSServer != "" {
+		dialer.Resolver = newResolver(req.DNSServer, req.Guard)
+	}
+	if len(req.Resolve) == 0 && !req.RoundRobin {
+		return dialer.DialContext, nil
+	}
+	pins, err := parseResolve(req.Resolve)
+	if err != nil {
+		return nil, err
+	}
+	d := &resolvingDialer{dialer: dialer, pins: pins, roundRobin: req.RoundRobin}
+	return d.DialContext, nil
+}
+
+func (d *resolvingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs := d.pins[net.JoinHostPort(strings.ToLower(host), port)]
+	if addrs == nil && d.roundRobin && net.ParseIP(host) == nil {
+		resolver := d.dialer.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		if addrs, err = resolver.LookupHost(ctx, host); err != nil {
+			return nil, err
+		}
+		sort.Strings(addrs) // stable order for the rotation
+	}
+	if len(addrs) == 0 {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+	addr := addrs[d.next.Add(1)%uint64(len(addrs))]
+	return d.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResultRecord is one line of a -results log. Durations are nanoseconds,
+// like in the JSON report.
+type ResultRecord struct {
+	Time       time.Time     `json:"time"` // Result.Start
+	Name       string        `json:"name,omitempty"`
+	URL        string        `json:"url"`
+	Stage      string        `json:"stage,omitempty"`
+	Status     int           `json:"status,omitempty"`
+	Latency    time.Duration `json:"latency"`
+	Delay      time.Duration `json:"delay,omitempty"`
+	Bytes      int64         `json:"bytes"`
+	Phases     *PhaseTimings `json:"phases,omitempty"`
+	Addr       string        `json:"addr,omitempty"`
+	Proto      string        `json:"proto,omitempty"`
+	Redirects  int           `json:"redirects,omitempty"`
+	FinalURL   string        `json:"final_url,omitempty"`
+	FirstHop   time.Duration `json:"first_hop,omitempty"`
+	TLSVersion string        `json:"tls_version,omitempty"`
+	TLSCipher  string        `json:"tls_cipher,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+	Checks     []CheckResult `json:"checks,omitempty"`
+	BodySample string        `json:"body_sample,omitempty"`
+}
+
+func newResultRecord(res Result, stages []Stage) ResultRecord {
+	rec := ResultRecord{
+		Time:       res.Start,
+		Name:       res.Name,
+		URL:        res.URL,
+		Status:     res.StatusCode,
+		Latency:    res.Duration,
+		Delay:      res.Delay,
+		Bytes:      res.Bytes,
+		Addr:       res.Addr,
+		Proto:      res.Proto,
+		Redirects:  res.Redirects,
+		FinalURL:   res.FinalURL,
+		FirstHop:   res.FirstHop,
+		TLSVersion: res.TLSVersion,
+		TLSCipher:  res.TLSCipher,
+		ErrorClass: res.ErrorClass,
+		Checks:     res.Checks,
+		BodySample: res.BodySample,
+	}
+	if len(stages) > 0 {
+		rec.Stage = stages[res.Stage].Name
+	}
+	if res.Err != nil {
+		rec.Error = res.Err.Error()
+	} else {
+		phases := res.Phases
+		rec.Phases = &phases
+	}
+	return rec
+}
+
+// Result turns the record back into what the collector consumes.
+func (rec ResultRecord) Result() Result {
+	res := Result{
+		Name:       rec.Name,
+		URL:        rec.URL,
+		Start:      rec.Time,
+		StatusCode: rec.Status,
+		Duration:   rec.Latency,
+		Delay:      rec.Delay,
+		Bytes:      rec.Bytes,
+		ErrorClass: rec.ErrorClass,
+		Addr:       rec.Addr,
+		Proto:      rec.Proto,
+		Redirects:  rec.Redirects,
+		FinalURL:   rec.FinalURL,
+		FirstHop:   rec.FirstHop,
+		TLSVersion: rec.TLSVersion,
+		TLSCipher:  rec.TLSCipher,
+		Checks:     rec.Checks,
+		BodySample: rec.BodySample,
+	}
+	if rec.Error != "" {
+		res.Err = errors.New(rec.Error)
+	}
+	if rec.Phases != nil {
+		res.Phases = *rec.Phases
+	}
+	return res
+}
+
+// resultLog streams Results as NDJSON. It is only used from the collector
+// loop; the first write error is kept and later writes are skipped.
+type resultLog struct {
+	w      *bufio.Writer
+	enc    *json.Encoder
+	stages []Stage
+	err    error
+}
+
+func newResultLog(w io.Writer, stages []Stage) *resultLog {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	return &resultLog{w: bw, enc: json.NewEncoder(bw), stages: stages}
+}
+
+func (l *resultLog) Write(res Result) {
+	if l.err == nil {
+		l.err = l.enc.Encode(newResultRecord(res, l.stages))
+	}
+}
+
+func (l *resultLog) Flush() error {
+	if l.err != nil {
+		return l.err
+	}
+	return l.w.Flush()
+}
+
+// ResultFilter selects records when replaying a log. From and To are
+// offsets from the first record; zero means unbounded.
+type ResultFilter struct {
+	From time.Duration
+	To   time.Duration
+	URL  string // substring of the URL or request name
+}
+
+func (f ResultFilter) match(rec ResultRecord, first time.Time) bool {
+	at := rec.Time.Sub(first)
+	if (f.From > 0 && at < f.From) || (f.To > 0 && at >= f.To) {
+		return false
+	}
+	return f.URL == "" || strings.Contains(rec.URL, f.URL) || strings.Contains(rec.Name, f.URL)
+}
+
+// replayGroup aggregates replayed records and the time span they cover.
+type replayGroup struct {
+	collector  *statsCollector
+	start, end time.Time
+}
+
+func (g *replayGroup) Add(rec ResultRecord, res Result) {
+	g.collector.Add(res)
+	if g.start.IsZero() || rec.Time.Before(g.start) {
+		g.start = rec.Time
+	}
+	if done := rec.Time.Add(rec.Latency); done.After(g.end) {
+		g.end = done
+	}
+}
+
+func (g *replayGroup) Stats(name string) ProbeStats {
+	stats := g.collector.Stats()
+	stats.Name = name
+	stats.Elapsed = g.end.Sub(g.start)
+	if stats.Elapsed > 0 {
+		stats.Throughput = float64(stats.TotalRequest) / stats.Elapsed.Seconds()
+	}
+	return stats
+}
+
+// ReplayResults recomputes ProbeStats from a -results log. Delayed and
+// Dropped are not recoverable, since dropped requests never produced a
+// record. The returned time is that of the first matching record.
+func ReplayResults(path string, filter ResultFilter, success StatusSet, bucket time.Duration) (ProbeStats, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ProbeStats{}, time.Time{}, err
+	}
+	defer f.Close()
+
+	newGroup := func() *replayGroup {
+		return &replayGroup{collector: newStatsCollector("", success, nil)}
+	}
+	all := newGroup()
+	var stages, requests, protos, addrs []string
+	byStage := make(map[string]*replayGroup)
+	byRequest := make(map[string]*replayGroup)
+	byProto := make(map[string]*replayGroup)
+	byAddr := make(map[string]*replayGroup)
+	urls := make(map[string]bool)
+	group := func(m map[string]*replayGroup, order *[]string, key, url string) *replayGroup {
+		g, ok := m[key]
+		if !ok {
+			g = newGroup()
+			g.collector.stats.TargetURL = url
+			m[key] = g
+			*order = append(*order, key)
+		}
+		return g
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	var first time.Time
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec ResultRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return ProbeStats{}, time.Time{}, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if first.IsZero() {
+			first = rec.Time
+			all.collector.series = newTimeline(first, bucket)
+		}
+		if !filter.match(rec, first) {
+			continue
+		}
+
+		res := rec.Result()
+		all.Add(rec, res)
+		if rec.Stage != "" {
+			group(byStage, &stages, rec.Stage, "").Add(rec, res)
+		}
+		if rec.Name != "" {
+			group(byRequest, &requests, rec.Name, rec.URL).Add(rec, res)
+		}
+		if rec.Proto != "" {
+			group(byProto, &protos, rec.Proto, "").Add(rec, res)
+		}
+		if rec.Addr != "" {
+			group(byAddr, &addrs, rec.Addr, "").Add(rec, res)
+		}
+		urls[rec.URL] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return ProbeStats{}, time.Time{}, err
+	}
+
+	stats := all.Stats("")
+	if len(urls) == 1 {
+		for url := range urls {
+			stats.TargetURL = url
+		}
+	}
+	for _, name := range stages {
+		stats.Stages = append(stats.Stages, byStage[name].Stats(name))
+	}
+	for _, name := range requests {
+		stats.Requests = append(stats.Requests, byRequest[name].Stats(name))
+	}
+	sort.Strings(protos)
+	for _, name := range protos {
+		stats.Protocols = append(stats.Protocols, byProto[name].Stats(name))
+	}
+	if len(addrs) > 1 {
+		sort.Strings(addrs)
+		for _, name := range addrs {
+			stats.Addrs = append(stats.Addrs, byAddr[name].Stats(name))
+		}
+	}
+	return stats, all.start, nil
+}
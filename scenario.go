@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Scenario is the on-disk format of a -scenario file.
+type Scenario struct {
+	Requests []ScenarioRequest `json:"requests"`
+	TLS      *TLSOptions       `json:"tls"` // PEM paths are relative to the file
+}
+
+// ScenarioRequest is one weighted entry of a traffic mix. URLs starting with
+// "/" are resolved against ProbeRequest.URL; headers are merged over the
+// run-wide ones.
+type ScenarioRequest struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Weight  float64           `json:"weight"` // defaults to 1
+	Checks  *Checks           `json:"checks"` // defaults to the run-wide checks
+}
+
+// LoadScenario reads a JSON scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("invalid scenario file: %v", err)
+	}
+	if len(sc.Requests) == 0 {
+		return nil, fmt.Errorf("scenario file has no requests")
+	}
+	if sc.TLS != nil {
+		sc.TLS.resolvePaths(filepath.Dir(path))
+	}
+	return &sc, nil
+}
+
+// requestMix picks the RequestSpec for each request according to weight.
+type requestMix struct {
+	specs      []*RequestSpec
+	cumulative []float64
+}
+
+func newRequestMix(req ProbeRequest) (*requestMix, error) {
+	if len(req.Scenario) == 0 {
+		spec, err := NewRequestSpec(req)
+		if err != nil {
+			return nil, err
+		}
+		return &requestMix{specs: []*RequestSpec{spec}, cumulative: []float64{1}}, nil
+	}
+
+	mix := &requestMix{}
+	names := make(map[string]bool)
+	var total float64
+	for i, sr := range req.Scenario {
+		if sr.Weight < 0 {
+			return nil, fmt.Errorf("scenario request %d: negative weight", i+1)
+		}
+		weight := sr.Weight
+		if weight == 0 {
+			weight = 1
+		}
+
+		url := sr.URL
+		if strings.HasPrefix(url, "/") && req.URL != "" {
+			url = strings.TrimRight(normalizeURL(req.URL), "/") + url
+		}
+		if url == "" {
+			return nil, fmt.Errorf("scenario request %d: missing url", i+1)
+		}
+
+		headers := make(map[string]string, len(req.Headers)+len(sr.Headers))
+		for k, v := range req.Headers {
+			headers[k] = v
+		}
+		for k, v := range sr.Headers {
+			headers[k] = v
+		}
+
+		name := sr.Name
+		if name == "" {
+			name = strings.TrimSpace(strings.ToUpper(sr.Method) + " " + url)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate scenario request name %q", name)
+		}
+		names[name] = true
+
+		checks := sr.Checks
+		if checks == nil {
+			checks = req.Checks
+		}
+		spec, err := newRequestSpec(name, sr.Method, url, headers, sr.Body, checks)
+		if err != nil {
+			return nil, fmt.Errorf("scenario request %q: %v", name, err)
+		}
+		total += weight
+		mix.specs = append(mix.specs, spec)
+		mix.cumulative = append(mix.cumulative, total)
+	}
+	return mix, nil
+}
+
+// Pick samples a spec. Only called from the feeder goroutine.
+func (m *requestMix) Pick() *RequestSpec {
+	if len(m.specs) == 1 {
+		return m.specs[0]
+	}
+	x := rand.Float64() * m.cumulative[len(m.cumulative)-1]
+	return m.specs[sort.SearchFloat64s(m.cumulative, x)]
+}
+
+// CheckNames lists every check of every spec, in definition order.
+func (m *requestMix) CheckNames() []string {
+	var names []string
+	for _, spec := range m.specs {
+		names = append(names, spec.Checks.Names()...)
+	}
+	return names
+}
+
+// Named reports whether the mix comes from a scenario.
+func (m *requestMix) Named() bool {
+	return m.specs[0].Name != ""
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -14,7 +18,7 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, dashboardHTML)
 }
 
-func StartWebServer(port int) {
+func StartWebServer(port int, cfg *ServerConfig) {
 	mux := http.NewServeMux()
 
 	loggingMiddleware := func(next http.Handler) http.Handler {
@@ -28,18 +32,39 @@ func StartWebServer(port int) {
 	mux.HandleFunc("/", handleIndex)
 	mux.HandleFunc("/api/probe", handleProbe)
 	mux.HandleFunc("/api/probe-stream", handleProbeStream) // NEW: Streaming endpoint
+	mux.HandleFunc("POST /api/jobs", handleCreateJob)
+	mux.HandleFunc("GET /api/jobs", handleListJobs)
+	mux.HandleFunc("GET /api/jobs/{id}", handleGetJob)
+	mux.HandleFunc("DELETE /api/jobs/{id}", handleCancelJob)
 
+	serverConfig = cfg
+	api := cfg.requireToken(mux)
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" && cfg.AllowOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
+		}
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-		loggingMiddleware(mux).ServeHTTP(w, r)
+		loggingMiddleware(api).ServeHTTP(w, r)
 	})
 
+	if len(cfg.Tokens) == 0 {
+		fmt.Printf("[!] No API tokens configured: the API is open to anyone who can reach it\n")
+	} else {
+		fmt.Printf("[*] %d API tokens loaded\n", len(cfg.Tokens))
+	}
+	if len(cfg.AllowInternal) > 0 {
+		fmt.Printf("[!] Internal ranges open to probes: %s\n", strings.Join(cfg.AllowInternal, ", "))
+	}
+	if len(cfg.Targets.Allow) == 0 {
+		fmt.Printf("[!] No target allowlist configured: any URL can be probed\n")
+	}
 	fmt.Printf("[*] MECHANIC CORE - Engine started on port %d\n", port)
 	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), handler); err != nil {
 		fmt.Printf("[CRITICAL] Server failure: %v\n", err)
@@ -47,10 +72,49 @@ func StartWebServer(port int) {
 }
 
 type ProbeRequest struct {
-	URL         string `json:"url"`
-	Concurrency int    `json:"concurrency"`
-	Count       int    `json:"count"`
-	Timeout     int    `json:"timeout"`
+	URL           string            `json:"url"`
+	Concurrency   int               `json:"concurrency"`
+	Count         int               `json:"count"`
+	Timeout       int               `json:"timeout"`
+	SuccessStatus string            `json:"success_status"` // e.g. "2xx" or "200,204"
+	Method        string            `json:"method"`         // defaults to HEAD, or POST with a body
+	Headers       map[string]string `json:"headers"`
+	Body          string            `json:"body"`
+	Rate          float64           `json:"rate"`       // requests/sec, 0 = closed loop
+	Duration      float64           `json:"duration"`   // seconds; overrides count when set
+	Stages        []Stage           `json:"stages"`     // load profile; overrides rate and duration
+	StageMode     string            `json:"stage_mode"` // "rate" (default) or "concurrency"
+	Scenario      []ScenarioRequest `json:"scenario"`   // weighted request mix; url becomes the base for relative entries
+	Checks        *Checks           `json:"checks"`     // response assertions
+	Thresholds    []string          `json:"thresholds"` // e.g. "p95<300ms", "error_rate<1%"
+	AbortOnFail   bool              `json:"abort_on_fail"`
+	Bucket        float64           `json:"bucket"` // timeline bucket width in seconds, default 1
+	TLS           *TLSOptions       `json:"tls"`
+	Protocol      string            `json:"protocol"`      // auto (default), http1, http2 or h2c
+	Redirect      string            `json:"redirect"`      // none (default), follow or same-host
+	MaxRedirects  int               `json:"max_redirects"` // default 10
+
+	// Connection handling; zero keeps the defaults
+	DisableKeepAlive    bool    `json:"disable_keep_alive"` // new connection per request
+	MaxConnsPerHost     int     `json:"max_conns_per_host"` // default unlimited
+	IdleConns           int     `json:"idle_conns"`         // idle pool per host, default 500
+	DialTimeout         float64 `json:"dial_timeout"`       // seconds, default 3
+	TLSHandshakeTimeout float64 `json:"tls_handshake_timeout"`
+
+	// DNS: curl-style "host:port:addr" pins, a resolver to use instead of
+	// the system's, and rotation over every address of the target
+	Resolve    []string `json:"resolve"`
+	DNSServer  string   `json:"dns_server"` // "ip" or "ip:port"
+	RoundRobin bool     `json:"round_robin"`
+
+	// CLI only: every Result is written here as NDJSON
+	ResultLog io.Writer `json:"-"`
+	// Set from the caller's quota: hard cap on requests sent
+	MaxRequests int `json:"-"`
+	// Set in web mode: refuses internal addresses, and redirects to hosts
+	// outside the allowlist
+	Guard  *AddrGuard    `json:"-"`
+	Policy *TargetPolicy `json:"-"`
 }
 
 // handleProbe - Original endpoint for small requests
@@ -66,12 +130,29 @@ func handleProbe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sanitizeRequest(&req)
+	if err := sanitizeRequest(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, stop := clientContext(r)
+	defer stop()
+
+	run, err := newProbeRun(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := admitRun(r, &req); err != nil {
+		run.stop()
+		writeError(w, err)
+		return
+	}
 
 	fmt.Printf("[EXE] Starting Probe -> Target: %s | Workers: %d | Total: %d\n", req.URL, req.Concurrency, req.Count)
 
 	start := time.Now()
-	stats := PerformProbe(req.URL, req.Concurrency, req.Count, req.Timeout)
+	stats := run.Run(nil)
 	duration := time.Since(start)
 
 	fmt.Printf("[RES] Probe Finished -> Success: %d | Errors: %d | Time: %v\n", stats.SuccessCount, stats.ErrorCount, duration)
@@ -81,6 +162,7 @@ func handleProbe(w http.ResponseWriter, r *http.Request) {
 		"status":     "success",
 		"results":    stats,
 		"latency_ms": stats.AvgLatency.Milliseconds(),
+		"p99_ms":     stats.Latency.P99.Milliseconds(),
 		"total_time": duration.String(),
 	})
 }
@@ -98,99 +180,132 @@ func handleProbeStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sanitizeRequest(&req)
+	if err := sanitizeRequest(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
 
-	// Setup streaming
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	ctx, stop := clientContext(r)
+	defer stop()
 
+	run, err := newProbeRun(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
-
-	fmt.Printf("[STREAM] Starting -> Target: %s | Workers: %d | Total: %d\n", req.URL, req.Concurrency, req.Count)
-
-	// Setup probe
-	if req.Timeout > 5 {
-		req.Timeout = 5
-	}
-	client := NewClient(time.Duration(req.Timeout) * time.Second)
-	targets := make(chan string, req.Count)
-	results := make(chan Result, req.Count)
-
-	var wg sync.WaitGroup
-	for i := 0; i < req.Concurrency; i++ {
-		wg.Add(1)
-		go Worker(i, targets, results, client, &wg)
+	if err := admitRun(r, &req); err != nil {
+		run.stop()
+		writeError(w, err)
+		return
 	}
 
-	for i := 0; i < req.Count; i++ {
-		targets <- req.URL
-	}
-	close(targets)
+	// Setup streaming
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+	fmt.Printf("[STREAM] Starting -> Target: %s | Workers: %d | Total: %d | Duration: %gs\n", req.URL, req.Concurrency, req.Count, req.Duration)
 
 	// Stream results in real-time
-	successCount := 0
-	errorCount := 0
-	var totalLatency time.Duration
-	processed := 0
-
-	for res := range results {
-		processed++
-		if res.Err != nil {
-			errorCount++
-		} else {
-			successCount++
-			totalLatency += res.Duration
+	sentBuckets := 0
+	stats := run.Run(func(res Result, collector *statsCollector) {
+		processed := collector.stats.TotalRequest
+
+		// Send progress every 50 results (or at the end), and whenever a
+		// new timeline bucket starts so live charts keep moving
+		timed := req.Duration > 0 || len(req.Stages) > 0
+		if processed%50 != 0 && (timed || processed != req.Count) && collector.series.Len() == sentBuckets {
+			return
 		}
+		snap := collector.Stats()
 
-		// Send progress every 50 results (or at the end)
-		if processed%50 == 0 || processed == req.Count {
-			avgLat := int64(0)
-			if successCount > 0 {
-				avgLat = (totalLatency / time.Duration(successCount)).Milliseconds()
-			}
-
-			data := map[string]interface{}{
-				"progress":   processed,
-				"total":      req.Count,
-				"success":    successCount,
-				"errors":     errorCount,
-				"latency_ms": avgLat,
-			}
-			jsonData, _ := json.Marshal(data)
-			fmt.Fprintf(w, "data: %s\n\n", jsonData)
-			flusher.Flush()
+		// Buckets still open may have changed since the last frame
+		from := sentBuckets - timelineOpen
+		if from < 0 {
+			from = 0
 		}
-	}
+		sentBuckets = len(snap.Timeline)
+		total := req.Count
+		if timed {
+			total = 0 // unknown up front
+		}
+		data := map[string]interface{}{
+			"progress":      processed,
+			"total":         total,
+			"success":       snap.SuccessCount,
+			"errors":        snap.ErrorCount,
+			"latency_ms":    snap.AvgLatency.Milliseconds(),
+			"p50_ms":        snap.Latency.P50.Milliseconds(),
+			"p99_ms":        snap.Latency.P99.Milliseconds(),
+			"status_codes":  snap.StatusCodes,
+			"error_classes": snap.ErrorClasses,
+			"timeline":      snap.Timeline[from:],
+		}
+		if len(req.Stages) > 0 {
+			data["stage"] = run.req.Stages[res.Stage].Name
+		}
+		jsonData, _ := json.Marshal(data)
+		fmt.Fprintf(w, "data: %s\n\n", jsonData)
+		flusher.Flush()
+	})
 
 	// Final message
-	avgLat := int64(0)
-	if successCount > 0 {
-		avgLat = (totalLatency / time.Duration(successCount)).Milliseconds()
-	}
 	finalData := map[string]interface{}{
-		"done":       true,
-		"success":    successCount,
-		"errors":     errorCount,
-		"latency_ms": avgLat,
+		"done":          true,
+		"success":       stats.SuccessCount,
+		"errors":        stats.ErrorCount,
+		"latency_ms":    stats.AvgLatency.Milliseconds(),
+		"latency":       stats.Latency,
+		"status_codes":  stats.StatusCodes,
+		"error_classes": stats.ErrorClasses,
+		"total":         stats.TotalRequest,
+		"elapsed_ms":    stats.Elapsed.Milliseconds(),
+		"throughput":    stats.Throughput,
+		"delayed":       stats.Delayed,
+		"dropped":       stats.Dropped,
+		"stages":        stats.Stages,
+		"requests":      stats.Requests,
+		"checks_failed": stats.ChecksFailed,
+		"checks":        stats.Checks,
+		"thresholds":    stats.Thresholds,
+		"passed":        stats.ThresholdsPassed,
+		"aborted":       stats.Aborted,
+		"timeline":      stats.Timeline,
 	}
 	jsonData, _ := json.Marshal(finalData)
 	fmt.Fprintf(w, "data: %s\n\n", jsonData)
 	flusher.Flush()
 
-	fmt.Printf("[STREAM] Done -> Success: %d | Errors: %d\n", successCount, errorCount)
+	if stats.Aborted != "" {
+		fmt.Printf("[STREAM] Aborted (%s) -> Partial: %d requests | Success: %d | Errors: %d | p99: %v\n", stats.Aborted, stats.TotalRequest, stats.SuccessCount, stats.ErrorCount, stats.Latency.P99)
+		return
+	}
+	fmt.Printf("[STREAM] Done -> Success: %d | Errors: %d | p99: %v\n", stats.SuccessCount, stats.ErrorCount, stats.Latency.P99)
+}
+
+// errClientDisconnected is the cancel cause when the HTTP client goes away
+// mid-run; it shows up in ProbeStats.Aborted.
+var errClientDisconnected = errors.New("client disconnected")
+
+// clientContext is cancelled with errClientDisconnected as soon as the
+// client of r disconnects.
+func clientContext(r *http.Request) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stop := context.AfterFunc(r.Context(), func() { cancel(errClientDisconnected) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
 }
 
-func sanitizeRequest(req *ProbeRequest) {
+// sanitizeRequest applies defaults and hard limits, then the caller's
+// quota and the target policy. The hourly run budget is left to admitRun.
+func sanitizeRequest(r *http.Request, req *ProbeRequest) error {
 	if req.Concurrency <= 0 {
 		req.Concurrency = 10
 	}
@@ -203,7 +318,89 @@ func sanitizeRequest(req *ProbeRequest) {
 	if req.Concurrency > 1000 {
 		req.Concurrency = 1000
 	}
-	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+	if req.Rate < 0 {
+		req.Rate = 0
+	}
+	if req.Rate > 10000 {
+		req.Rate = 10000
+	}
+	if req.Duration < 0 {
+		req.Duration = 0
+	}
+	if req.Bucket < 0 {
+		req.Bucket = 0
+	}
+	if req.DialTimeout > 10 {
+		req.DialTimeout = 10
+	}
+	if req.TLSHandshakeTimeout > 10 {
+		req.TLSHandshakeTimeout = 10
+	}
+	if req.StageMode == StageModeConcurrency {
+		for i := range req.Stages {
+			if req.Stages[i].Target > 1000 {
+				req.Stages[i].Target = 1000
+			}
+		}
+	} else {
+		for i := range req.Stages {
+			if req.Stages[i].Target > 10000 {
+				req.Stages[i].Target = 10000
+			}
+		}
+	}
+	if req.URL != "" && !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
 		req.URL = "http://" + req.URL
 	}
+
+	req.Guard = serverConfig.guard
+	req.Policy = &serverConfig.Targets
+	// Pinning a verified or allowlisted name to another address would
+	// sidestep the target policy
+	policy := serverConfig.Targets
+	if (len(policy.Allow) > 0 || policy.VerifyAbove > 0) && (len(req.Resolve) > 0 || req.DNSServer != "") {
+		return policyError("resolve and dns_server are not accepted while a target policy is configured")
+	}
+	if req.Guard != nil && req.DNSServer != "" {
+		host := req.DNSServer
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if addr, err := netip.ParseAddr(host); err == nil && req.Guard.Blocked(addr) {
+			return policyError("dns_server %s is an internal address", host)
+		}
+	}
+	if req.MaxRedirects > 20 {
+		req.MaxRedirects = 20
+	}
+	if req.TLS != nil && req.TLS.usesFiles() {
+		return fmt.Errorf("tls: the web API only accepts inline PEM for ca, cert and key")
+	}
+
+	err := enforceQuota(req, tokenFrom(r))
+	if err == nil {
+		err = serverConfig.Targets.Check(r.Context(), req)
+	}
+	if err != nil {
+		logRefused(r, req, err)
+	}
+	return err
+}
+
+// admitRun counts a validated run against the caller's hourly budget.
+// Call it after newProbeRun, right before the run starts.
+func admitRun(r *http.Request, req *ProbeRequest) error {
+	err := takeRun(tokenFrom(r))
+	if err != nil {
+		logRefused(r, req, err)
+	}
+	return err
+}
+
+func logRefused(r *http.Request, req *ProbeRequest, err error) {
+	caller := r.RemoteAddr
+	if token := tokenFrom(r); token != nil {
+		caller = token.Name
+	}
+	fmt.Printf("[AUTH] Refused run from %s -> Target: %s | %v\n", caller, req.URL, err)
 }
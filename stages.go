@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stage modes: what Stage.Target is measured in.
+const (
+	StageModeRate        = "rate"        // requests/sec, open loop
+	StageModeConcurrency = "concurrency" // requests in flight, closed loop
+)
+
+// Stage is one step of a load profile. The load moves linearly from the
+// previous stage's target (0 for the first stage) to Target over Duration.
+// A zero Duration jumps straight to Target.
+type Stage struct {
+	Name     string  `json:"name,omitempty"`
+	Duration float64 `json:"duration"` // seconds
+	Target   float64 `json:"target"`
+}
+
+// ParseStages reads a profile either inline ("60s:200,5m:200,30s:0", each
+// step optionally prefixed with "name=") or from a JSON file ("@stages.json")
+// holding an array of Stage.
+func ParseStages(arg string) ([]Stage, error) {
+	if strings.HasPrefix(arg, "@") {
+		data, err := os.ReadFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		var stages []Stage
+		if err := json.Unmarshal(data, &stages); err != nil {
+			return nil, fmt.Errorf("invalid stages file: %v", err)
+		}
+		return stages, nil
+	}
+
+	var stages []Stage
+	for _, part := range strings.Split(arg, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var st Stage
+		if name, rest, ok := strings.Cut(part, "="); ok {
+			st.Name = strings.TrimSpace(name)
+			part = rest
+		}
+		dur, target, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid stage %q (expected duration:target)", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(dur))
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage duration %q", dur)
+		}
+		t, err := strconv.ParseFloat(strings.TrimSpace(target), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage target %q", target)
+		}
+		st.Duration = d.Seconds()
+		st.Target = t
+		stages = append(stages, st)
+	}
+	return stages, nil
+}
+
+// validateStages fills in default names and checks the profile.
+func validateStages(stages []Stage, mode string) error {
+	if len(stages) == 0 {
+		return nil
+	}
+	if mode != StageModeRate && mode != StageModeConcurrency {
+		return fmt.Errorf("invalid stage mode %q (expected %s or %s)", mode, StageModeRate, StageModeConcurrency)
+	}
+
+	var total float64
+	for i := range stages {
+		st := &stages[i]
+		if math.IsNaN(st.Duration) || math.IsNaN(st.Target) || math.IsInf(st.Duration, 0) || math.IsInf(st.Target, 0) {
+			return fmt.Errorf("stage %d: duration and target must be finite", i+1)
+		}
+		if st.Duration < 0 || st.Target < 0 {
+			return fmt.Errorf("stage %d: duration and target must not be negative", i+1)
+		}
+		if mode == StageModeRate && st.Target > maxRate {
+			return fmt.Errorf("stage %d: target must be at most %g req/s", i+1, maxRate)
+		}
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("stage-%d", i+1)
+		}
+		total += st.Duration
+	}
+	if total <= 0 {
+		return fmt.Errorf("stages have no duration")
+	}
+	return nil
+}
+
+func stagesDuration(stages []Stage) time.Duration {
+	var total float64
+	for _, st := range stages {
+		total += st.Duration
+	}
+	return time.Duration(total * float64(time.Second))
+}
+
+func stagesMaxTarget(stages []Stage) float64 {
+	var max float64
+	for _, st := range stages {
+		max = math.Max(max, st.Target)
+	}
+	return max
+}
+
+// stageAt returns the stage active at elapsed and the load level at that
+// instant. ok is false once the profile is over.
+func stageAt(stages []Stage, elapsed time.Duration) (idx int, level float64, ok bool) {
+	var from float64
+	at := elapsed.Seconds()
+	for i, st := range stages {
+		if at < st.Duration {
+			return i, from + (st.Target-from)*at/st.Duration, true
+		}
+		at -= st.Duration
+		from = st.Target
+	}
+	return len(stages) - 1, from, false
+}
+
+// feedStagedRate is the open-loop feeder for a rate profile. Virtual time
+// advances in small steps and accumulates rate*step credit, so ramps that
+// start from zero still send their first request on time.
+func (r *probeRun) feedStagedRate(targets chan<- Target, start time.Time) {
+	const maxStep = 5 * time.Millisecond
+	maxLag := time.Duration(r.req.Timeout) * time.Second
+
+	at := start
+	credit := 1.0
+	for sent := 0; ; {
+		idx, rate, ok := stageAt(r.req.Stages, at.Sub(start))
+		if !ok || r.capped(sent) {
+			return
+		}
+
+		if credit >= 1 {
+			credit--
+			if !r.sleepUntil(at) {
+				return
+			}
+			r.sendScheduled(targets, Target{Spec: r.mix.Pick(), Scheduled: at, Stage: idx}, maxLag)
+			sent++
+		}
+
+		step := maxStep
+		if rate > 0 {
+			if interval := time.Duration(float64(time.Second) / rate); interval < step {
+				step = max(interval, time.Nanosecond)
+			}
+		}
+		at = at.Add(step)
+		credit += rate * step.Seconds()
+	}
+}
+
+// feedStagedConcurrency is the closed-loop feeder for a concurrency profile:
+// it keeps as many requests in flight as the current level allows, and
+// re-checks the level whenever a request completes or the tick fires.
+func (r *probeRun) feedStagedConcurrency(targets chan<- Target, start time.Time) {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+
+	for sent := 0; ; {
+		idx, level, ok := stageAt(r.req.Stages, time.Since(start))
+		if !ok || r.capped(sent) {
+			return
+		}
+		if r.inflight.Load() < int64(math.Ceil(level)) {
+			r.inflight.Add(1)
+			select {
+			case targets <- Target{Spec: r.mix.Pick(), Stage: idx}:
+				sent++
+			case <-r.ctx.Done():
+				return
+			}
+			continue
+		}
+		select {
+		case <-r.completed:
+		case <-tick.C:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
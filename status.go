@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultSuccessStatus is used when no success criteria is given. A 3xx
+// counts as success: it is the final response under the default redirect
+// policy (none), and when same-host stops at a redirect to another host.
+// Followed redirects are judged on the status at the end of the chain.
+const DefaultSuccessStatus = "2xx,3xx"
+
+// StatusSet decides which HTTP status codes count as a successful request.
+type StatusSet []statusRange
+
+type statusRange struct {
+	lo, hi int
+}
+
+// ParseStatusSet parses a comma separated list of classes ("2xx"), single
+// codes ("204") and inclusive ranges ("200-299").
+func ParseStatusSet(spec string) (StatusSet, error) {
+	if strings.TrimSpace(spec) == "" {
+		spec = DefaultSuccessStatus
+	}
+
+	var set StatusSet
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		switch {
+		case len(part) == 3 && strings.HasSuffix(part, "xx"):
+			class := int(part[0] - '0')
+			if class < 1 || class > 5 {
+				return nil, fmt.Errorf("invalid status class %q", part)
+			}
+			set = append(set, statusRange{class * 100, class*100 + 99})
+
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			lo, err1 := parseStatusCode(bounds[0])
+			hi, err2 := parseStatusCode(bounds[1])
+			if err1 != nil || err2 != nil || lo > hi {
+				return nil, fmt.Errorf("invalid status range %q", part)
+			}
+			set = append(set, statusRange{lo, hi})
+
+		default:
+			code, err := parseStatusCode(part)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, statusRange{code, code})
+		}
+	}
+
+	if len(set) == 0 {
+		return nil, fmt.Errorf("empty status set %q", spec)
+	}
+	return set, nil
+}
+
+func parseStatusCode(s string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("invalid status code %q", s)
+	}
+	return code, nil
+}
+
+func (s StatusSet) Contains(code int) bool {
+	for _, r := range s {
+		if code >= r.lo && code <= r.hi {
+			return true
+		}
+	}
+	return false
+}
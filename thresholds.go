@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold is a parsed pass/fail expression such as "p95<300ms",
+// "error_rate<1%" or "rps>200".
+type Threshold struct {
+	Expr   string
+	Metric string
+	Op     string
+	Value  float64 // milliseconds for latencies, a fraction for rates
+}
+
+// ThresholdResult is a Threshold evaluated against a ProbeStats.
+type ThresholdResult struct {
+	Expr   string  `json:"expr"`
+	Actual float64 `json:"actual"` // same unit as the threshold value
+	OK     bool    `json:"ok"`
+}
+
+type metricKind int
+
+const (
+	metricLatency metricKind = iota // milliseconds
+	metricRate                      // fraction of requests
+	metricCount
+	metricPerSecond
+)
+
+var thresholdMetrics = map[string]metricKind{
+	"min": metricLatency, "max": metricLatency, "avg": metricLatency, "mean": metricLatency, "stddev": metricLatency,
+	"p50": metricLatency, "p90": metricLatency, "p95": metricLatency, "p99": metricLatency, "p99.9": metricLatency,
+	"error_rate": metricRate, "success_rate": metricRate,
+	"errors": metricCount, "requests": metricCount, "checks_failed": metricCount, "dropped": metricCount, "delayed": metricCount,
+	"rps": metricPerSecond,
+}
+
+var latencyQuantiles = map[string]float64{
+	"p50": 0.50, "p90": 0.90, "p95": 0.95, "p99": 0.99, "p99.9": 0.999,
+}
+
+// ParseThreshold parses "<metric><op><value>". Latency values take a unit
+// (300ms, 1.5s; bare numbers are milliseconds) and rates may be given as a
+// percentage (1%) or a fraction (0.01).
+func ParseThreshold(expr string) (Threshold, error) {
+	compact := strings.ReplaceAll(strings.TrimSpace(expr), " ", "")
+	idx := strings.IndexAny(compact, "<>")
+	if idx <= 0 {
+		return Threshold{}, fmt.Errorf("invalid threshold %q (expected e.g. p95<300ms)", expr)
+	}
+	t := Threshold{Expr: compact, Metric: strings.ToLower(compact[:idx]), Op: compact[idx : idx+1]}
+	rest := compact[idx+1:]
+	if strings.HasPrefix(rest, "=") {
+		t.Op += "="
+		rest = rest[1:]
+	}
+
+	kind, ok := thresholdMetrics[t.Metric]
+	if !ok {
+		return Threshold{}, fmt.Errorf("threshold %q: unknown metric %q", expr, t.Metric)
+	}
+
+	var err error
+	switch kind {
+	case metricLatency:
+		if n, perr := strconv.ParseFloat(rest, 64); perr == nil {
+			t.Value = n
+		} else {
+			var d time.Duration
+			d, err = time.ParseDuration(rest)
+			t.Value = durationMs(d)
+		}
+	case metricRate:
+		t.Value, err = parseRate(rest)
+	default:
+		t.Value, err = strconv.ParseFloat(rest, 64)
+	}
+	if err != nil || rest == "" {
+		return Threshold{}, fmt.Errorf("threshold %q: invalid value %q", expr, rest)
+	}
+	return t, nil
+}
+
+// parseRate reads a percentage ("1%") or a fraction ("0.01").
+func parseRate(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		return v / 100, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func ParseThresholds(exprs []string) ([]Threshold, error) {
+	var out []Threshold
+	for _, expr := range exprs {
+		for _, part := range strings.Split(expr, ",") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			t, err := ParseThreshold(part)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// actual extracts the metric from stats in the threshold's unit.
+func (t Threshold) actual(stats ProbeStats) float64 {
+	lat := stats.Latency
+	switch t.Metric {
+	case "min":
+		return durationMs(lat.Min)
+	case "max":
+		return durationMs(lat.Max)
+	case "avg", "mean":
+		return durationMs(lat.Mean)
+	case "stddev":
+		return durationMs(lat.StdDev)
+	case "p50":
+		return durationMs(lat.P50)
+	case "p90":
+		return durationMs(lat.P90)
+	case "p95":
+		return durationMs(lat.P95)
+	case "p99":
+		return durationMs(lat.P99)
+	case "p99.9":
+		return durationMs(lat.P999)
+	case "error_rate":
+		if stats.TotalRequest == 0 {
+			return 0
+		}
+		return float64(stats.ErrorCount) / float64(stats.TotalRequest)
+	case "success_rate":
+		if stats.TotalRequest == 0 {
+			return 0
+		}
+		return float64(stats.SuccessCount) / float64(stats.TotalRequest)
+	case "errors":
+		return float64(stats.ErrorCount)
+	case "requests":
+		return float64(stats.TotalRequest)
+	case "checks_failed":
+		return float64(stats.ChecksFailed)
+	case "dropped":
+		return float64(stats.Dropped)
+	case "delayed":
+		return float64(stats.Delayed)
+	case "rps":
+		return stats.Throughput
+	}
+	return 0
+}
+
+func (t Threshold) holds(actual float64) bool {
+	switch t.Op {
+	case "<":
+		return actual < t.Value
+	case "<=":
+		return actual <= t.Value
+	case ">":
+		return actual > t.Value
+	default: // ">="
+		return actual >= t.Value
+	}
+}
+
+func (t Threshold) Evaluate(stats ProbeStats) ThresholdResult {
+	actual := t.actual(stats)
+	return ThresholdResult{Expr: t.Expr, Actual: actual, OK: t.holds(actual)}
+}
+
+// EvaluateThresholds checks every threshold; ok is false if any failed.
+func EvaluateThresholds(thresholds []Threshold, stats ProbeStats) ([]ThresholdResult, bool) {
+	ok := true
+	results := make([]ThresholdResult, len(thresholds))
+	for i, t := range thresholds {
+		results[i] = t.Evaluate(stats)
+		ok = ok && results[i].OK
+	}
+	return results, ok
+}
+
+// Breached reports whether the threshold can no longer pass, whatever the
+// remaining requests do. planned is the total request count of the run, or
+// 0 when it is not known up front (duration and stage runs); only
+// monotonic metrics can be decided early in that case.
+func (t Threshold) Breached(stats ProbeStats, planned int) bool {
+	upper := t.Op == "<" || t.Op == "<="
+
+	switch t.Metric {
+	case "errors", "checks_failed", "dropped", "delayed", "max":
+		// Only ever grow
+		return upper && !t.holds(t.actual(stats))
+	case "min":
+		// Only ever shrinks
+		return !upper && !t.holds(t.actual(stats))
+	}
+
+	if planned <= 0 {
+		return false
+	}
+	remaining := float64(planned - stats.TotalRequest)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	switch t.Metric {
+	case "requests":
+		return upper && !t.holds(t.actual(stats))
+	case "error_rate":
+		if upper {
+			return !t.holds(float64(stats.ErrorCount) / float64(planned))
+		}
+		return !t.holds((float64(stats.ErrorCount) + remaining) / float64(planned))
+	case "success_rate":
+		if upper {
+			return !t.holds(float64(stats.SuccessCount) / float64(planned))
+		}
+		return !t.holds((float64(stats.SuccessCount) + remaining) / float64(planned))
+	}
+
+	if q, ok := latencyQuantiles[t.Metric]; ok && upper && stats.Histogram != nil {
+		// The quantile stays above the limit once more samples exceed it
+		// than the final run could absorb.
+		limit := time.Duration(t.Value * float64(time.Millisecond))
+		above := float64(stats.Histogram.CountAbove(limit))
+		return above > (1-q)*float64(planned)
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import "time"
+
+// TimeBucket is one fixed-width slice of a run, keyed by when requests
+// completed.
+type TimeBucket struct {
+	Start    time.Duration `json:"start"` // offset from the start of the run
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	RPS      float64       `json:"rps"`
+	Mean     time.Duration `json:"mean"`
+	P50      time.Duration `json:"p50"`
+	P99      time.Duration `json:"p99"`
+}
+
+// Timeline bucket widths: the default, and the smallest accepted.
+const (
+	defaultBucket = time.Second
+	minBucket     = 100 * time.Millisecond
+)
+
+// bucketWidth converts ProbeRequest.Bucket (seconds) to a width.
+func bucketWidth(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return defaultBucket
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// timelineOpen is how many of the most recent buckets keep a histogram.
+// Results arrive roughly in completion order, so older buckets are final
+// and only keep their summary; a straggler still counts towards Requests
+// and Errors.
+const timelineOpen = 3
+
+// timeline aggregates Results into TimeBuckets with bounded memory.
+type timeline struct {
+	start   time.Time
+	width   time.Duration
+	buckets []TimeBucket
+	hists   map[int]*Histogram // open buckets only
+	spare   []*Histogram
+}
+
+func newTimeline(start time.Time, width time.Duration) *timeline {
+	return &timeline{start: start, width: width, hists: make(map[int]*Histogram)}
+}
+
+func (t *timeline) Add(res Result, failed bool) {
+	idx := int(res.Start.Add(res.Duration).Sub(t.start) / t.width)
+	if idx < 0 {
+		idx = 0
+	}
+	for len(t.buckets) <= idx {
+		t.buckets = append(t.buckets, TimeBucket{Start: time.Duration(len(t.buckets)) * t.width})
+	}
+	t.close(idx - timelineOpen)
+
+	b := &t.buckets[idx]
+	b.Requests++
+	if failed {
+		b.Errors++
+	}
+	if res.Err != nil {
+		return
+	}
+	if h, ok := t.hists[idx]; ok {
+		h.Record(res.Duration)
+	} else if idx > len(t.buckets)-1-timelineOpen {
+		h = t.histogram()
+		h.Record(res.Duration)
+		t.hists[idx] = h
+	}
+}
+
+// close finalizes every open bucket up to and including idx.
+func (t *timeline) close(idx int) {
+	for i, h := range t.hists {
+		if i > idx {
+			continue
+		}
+		t.summarize(i, h)
+		delete(t.hists, i)
+		*h = Histogram{}
+		t.spare = append(t.spare, h)
+	}
+}
+
+func (t *timeline) histogram() *Histogram {
+	if n := len(t.spare); n > 0 {
+		h := t.spare[n-1]
+		t.spare = t.spare[:n-1]
+		return h
+	}
+	return NewHistogram()
+}
+
+func (t *timeline) summarize(idx int, h *Histogram) {
+	b := &t.buckets[idx]
+	b.Mean = h.Mean()
+	b.P50 = h.Quantile(0.50)
+	b.P99 = h.Quantile(0.99)
+}
+
+// Len is the number of buckets so far.
+func (t *timeline) Len() int {
+	return len(t.buckets)
+}
+
+// Buckets returns a snapshot; open buckets are summarized as they stand.
+func (t *timeline) Buckets() []TimeBucket {
+	if t == nil || len(t.buckets) == 0 {
+		return nil
+	}
+	for i, h := range t.hists {
+		t.summarize(i, h)
+	}
+	out := make([]TimeBucket, len(t.buckets))
+	copy(out, t.buckets)
+	for i := range out {
+		out[i].RPS = float64(out[i].Requests) / t.width.Seconds()
+	}
+	return out
+}
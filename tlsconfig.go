@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TLSOptions configures the client side of TLS. CA, Cert and Key take a
+// PEM file path or the PEM itself (the web API only accepts inline PEM).
+type TLSOptions struct {
+	Insecure   bool     `json:"insecure"` // skip certificate verification
+	CA         string   `json:"ca"`       // trusted roots instead of the system pool
+	Cert       string   `json:"cert"`     // client certificate (mTLS), needs Key
+	Key        string   `json:"key"`
+	ServerName string   `json:"server_name"` // SNI and verification name override
+	MinVersion string   `json:"min_version"` // "1.0" to "1.3", default 1.2
+	MaxVersion string   `json:"max_version"`
+	Ciphers    []string `json:"ciphers"` // e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; TLS 1.3 suites are fixed
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func isInlinePEM(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), "-----BEGIN")
+}
+
+func readPEM(s string) ([]byte, error) {
+	if isInlinePEM(s) {
+		return []byte(s), nil
+	}
+	return os.ReadFile(s)
+}
+
+// usesFiles reports whether any PEM option is a file path.
+func (o *TLSOptions) usesFiles() bool {
+	for _, s := range []string{o.CA, o.Cert, o.Key} {
+		if s != "" && !isInlinePEM(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePaths makes relative PEM paths relative to dir.
+func (o *TLSOptions) resolvePaths(dir string) {
+	for _, s := range []*string{&o.CA, &o.Cert, &o.Key} {
+		if *s != "" && !isInlinePEM(*s) && !filepath.IsAbs(*s) {
+			*s = filepath.Join(dir, *s)
+		}
+	}
+}
+
+// redacted hides an inline private key.
+func (o *TLSOptions) redacted() *TLSOptions {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	if isInlinePEM(c.Key) {
+		c.Key = "REDACTED"
+	}
+	return &c
+}
+
+func parseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q (want 1.0, 1.1, 1.2 or 1.3)", s)
+	}
+	return v, nil
+}
+
+func cipherSuiteID(name string) (uint16, error) {
+	for _, list := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, cs := range list {
+			if strings.EqualFold(cs.Name, strings.TrimSpace(name)) {
+				return cs.ID, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+// Config builds the tls.Config; nil options verify with system roots.
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o == nil {
+		return cfg, nil
+	}
+	cfg.InsecureSkipVerify = o.Insecure
+	cfg.ServerName = o.ServerName
+
+	if o.CA != "" {
+		data, err := readPEM(o.CA)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("tls ca: no certificate found")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.Cert != "" || o.Key != "" {
+		if o.Cert == "" || o.Key == "" {
+			return nil, fmt.Errorf("tls: client cert and key must be given together")
+		}
+		certPEM, err := readPEM(o.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("tls cert: %v", err)
+		}
+		keyPEM, err := readPEM(o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("tls key: %v", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("tls cert: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.MinVersion != "" {
+		v, err := parseTLSVersion(o.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MinVersion = v
+	}
+	if o.MaxVersion != "" {
+		v, err := parseTLSVersion(o.MaxVersion)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MaxVersion = v
+	}
+	if cfg.MaxVersion != 0 && cfg.MaxVersion < cfg.MinVersion {
+		return nil, fmt.Errorf("tls: max version is below min version")
+	}
+
+	for _, name := range o.Ciphers {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	return cfg, nil
+}
+
+// tlsLabel names the negotiated version and cipher, e.g. "TLS 1.3" and
+// "TLS_AES_128_GCM_SHA256".
+func tlsLabel(state *tls.ConnectionState) (version, cipher string) {
+	if state == nil {
+		return "", ""
+	}
+	return tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// PhaseTimings breaks a request down into its network phases. DNS, Connect
+// and TLS are zero when an existing connection was reused.
+type PhaseTimings struct {
+	DNS      time.Duration `json:"dns"`
+	Connect  time.Duration `json:"connect"`
+	TLS      time.Duration `json:"tls"`
+	Server   time.Duration `json:"server"`   // request written -> first response byte
+	TTFB     time.Duration `json:"ttfb"`     // request start -> first response byte
+	Transfer time.Duration `json:"transfer"` // first response byte -> body fully read
+	Reused   bool          `json:"reused"`
+}
+
+// PhaseStats aggregates PhaseTimings across a run.
+type PhaseStats struct {
+	DNS      LatencySummary `json:"dns"`
+	Connect  LatencySummary `json:"connect"`
+	TLS      LatencySummary `json:"tls"`
+	Server   LatencySummary `json:"server"`
+	TTFB     LatencySummary `json:"ttfb"`
+	Transfer LatencySummary `json:"transfer"`
+
+	NewConns    int `json:"new_conns"`
+	ReusedConns int `json:"reused_conns"`
+	// TTFB split by connection, showing what opening a connection costs
+	NewConnTTFB    LatencySummary `json:"new_conn_ttfb"`
+	ReusedConnTTFB LatencySummary `json:"reused_conn_ttfb"`
+}
+
+// phaseTrace records httptrace callbacks for a single request. Dial callbacks
+// can fire from other goroutines, hence the mutex.
+type phaseTrace struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wrote        time.Time
+	firstByte    time.Time
+	firstHop     time.Time // first response when redirects are followed
+	redirects    int
+	reused       bool
+	remote       string // IP of the connection used, or last dialed
+}
+
+// phaseTraceKey carries the phaseTrace in the request context, for the
+// client's CheckRedirect.
+type phaseTraceKey struct{}
+
+func (t *phaseTrace) redirected() {
+	t.mu.Lock()
+	t.redirects++
+	t.mu.Unlock()
+}
+
+func (t *phaseTrace) setRemote(addr string) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	t.mu.Lock()
+	t.remote = addr
+	t.mu.Unlock()
+}
+
+// Remote returns the IP the request went to, if a connection was made.
+func (t *phaseTrace) Remote() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.remote
+}
+
+// Redirects returns how many redirects were followed and when the first
+// response arrived.
+func (t *phaseTrace) Redirects() (int, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.redirects, t.firstHop
+}
+
+func (t *phaseTrace) mark(field *time.Time, keepFirst bool) {
+	now := time.Now()
+	t.mu.Lock()
+	if !keepFirst || field.IsZero() {
+		*field = now
+	}
+	t.mu.Unlock()
+}
+
+func (t *phaseTrace) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart, true) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.mark(&t.dnsDone, false) },
+		ConnectStart: func(_, addr string) {
+			t.mark(&t.connectStart, true)
+			t.setRemote(addr)
+		},
+		ConnectDone:          func(_, _ string, _ error) { t.mark(&t.connectDone, false) },
+		TLSHandshakeStart:    func() { t.mark(&t.tlsStart, true) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.mark(&t.tlsDone, false) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.wrote, false) },
+		GotFirstResponseByte: func() { t.mark(&t.firstByte, true) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+			t.setRemote(info.Conn.RemoteAddr().String())
+		},
+	}
+}
+
+// Timings computes the phases; end is when the body was fully read.
+func (t *phaseTrace) Timings(end time.Time) PhaseTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := PhaseTimings{Reused: t.reused}
+	if t.reused {
+		return t.responseTimings(p, end)
+	}
+	p.DNS = span(t.dnsStart, t.dnsDone)
+	p.Connect = span(t.connectStart, t.connectDone)
+	p.TLS = span(t.tlsStart, t.tlsDone)
+	return t.responseTimings(p, end)
+}
+
+func (t *phaseTrace) responseTimings(p PhaseTimings, end time.Time) PhaseTimings {
+	p.Server = span(t.wrote, t.firstByte)
+	p.TTFB = span(t.start, t.firstByte)
+	p.Transfer = span(t.firstByte, end)
+	return p
+}
+
+func span(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// phaseCollector feeds PhaseTimings into one histogram per phase.
+type phaseCollector struct {
+	dns, connect, tls, server, ttfb, transfer *Histogram
+	newTTFB, reusedTTFB                       *Histogram
+	newConns, reusedConns                     int
+}
+
+func newPhaseCollector() *phaseCollector {
+	return &phaseCollector{
+		dns:        NewHistogram(),
+		connect:    NewHistogram(),
+		tls:        NewHistogram(),
+		server:     NewHistogram(),
+		ttfb:       NewHistogram(),
+		transfer:   NewHistogram(),
+		newTTFB:    NewHistogram(),
+		reusedTTFB: NewHistogram(),
+	}
+}
+
+func (c *phaseCollector) Add(p PhaseTimings) {
+	if p.Reused {
+		c.reusedConns++
+		c.reusedTTFB.Record(p.TTFB)
+	} else {
+		c.newConns++
+		c.newTTFB.Record(p.TTFB)
+		if p.DNS > 0 {
+			c.dns.Record(p.DNS)
+		}
+		if p.Connect > 0 {
+			c.connect.Record(p.Connect)
+		}
+		if p.TLS > 0 {
+			c.tls.Record(p.TLS)
+		}
+	}
+	c.server.Record(p.Server)
+	c.ttfb.Record(p.TTFB)
+	c.transfer.Record(p.Transfer)
+}
+
+func (c *phaseCollector) Stats() PhaseStats {
+	return PhaseStats{
+		DNS:            c.dns.Summary(),
+		Connect:        c.connect.Summary(),
+		TLS:            c.tls.Summary(),
+		Server:         c.server.Summary(),
+		TTFB:           c.ttfb.Summary(),
+		Transfer:       c.transfer.Summary(),
+		NewConns:       c.newConns,
+		ReusedConns:    c.reusedConns,
+		NewConnTTFB:    c.newTTFB.Summary(),
+		ReusedConnTTFB: c.reusedTTFB.Summary(),
+	}
+}
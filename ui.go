@@ -314,6 +314,11 @@ const dashboardHTML = `
                 </div>
             </div>
 
+            <div class="input-box">
+                <label>API Token</label>
+                <input type="password" id="apiToken" placeholder="only if the engine requires one">
+            </div>
+
             <button class="btn-launch" id="launchBtn">ENGAGE CORE</button>
         </div>
 
@@ -363,7 +368,26 @@ const dashboardHTML = `
             consoleOut.prepend(entry);
         }
 
+        const tokenInput = document.getElementById('apiToken');
+        if (localStorage.getItem('m_token')) tokenInput.value = localStorage.getItem('m_token');
+
+        // Explains auth, quota and target policy refusals instead of a bare status code
+        function apiError(r, text) {
+            if (r.status === 401) return 'ACCESS DENIED: missing or invalid API token';
+            if (r.status === 403) return 'REFUSED: ' + text;
+            if (r.status === 429) {
+                const wait = r.headers.get('Retry-After');
+                return 'RATE LIMITED: ' + text + (wait ? ' (retry in ' + wait + 's)' : '');
+            }
+            return 'CORE ERROR ' + r.status + ': ' + (text.length > 50 ? text.substring(0, 50) + "..." : text);
+        }
+
         launchBtn.onclick = async () => {
+            const token = tokenInput.value.trim();
+            localStorage.setItem('m_token', token);
+            const headers = { 'Content-Type': 'application/json' };
+            if (token) headers['Authorization'] = 'Bearer ' + token;
+
             const body = {
                 url: document.getElementById('target').value,
                 concurrency: parseInt(document.getElementById('workers').value),
@@ -379,13 +403,13 @@ const dashboardHTML = `
             try {
                 const r = await fetch('/api/probe', {
                     method: 'POST',
-                    headers: { 'Content-Type': 'application/json' },
+                    headers: headers,
                     body: JSON.stringify(body)
                 });
 
                 if(!r.ok) {
-                    const text = await r.text();
-                    throw new Error('CORE ERROR ' + r.status + ': ' + (text.length > 50 ? text.substring(0, 50) + "..." : text));
+                    const text = (await r.text()).trim();
+                    throw new Error(apiError(r, text));
                 }
                 
                 const data = await r.json();
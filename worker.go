@@ -1,62 +1,194 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
+// Target is a unit of work for a Worker. Scheduled is only set in open-loop
+// mode: latency is then measured from the intended send time, so time spent
+// waiting for a free worker is not hidden (coordinated omission).
+type Target struct {
+	Spec      *RequestSpec
+	Scheduled time.Time
+	Stage     int // index into ProbeRequest.Stages
+}
+
 // Result holds the outcome of a request
 type Result struct {
+	Name       string // RequestSpec.Name
 	URL        string
+	Start      time.Time // when the request was due (scheduled or sent)
 	StatusCode int
 	Duration   time.Duration
+	Delay      time.Duration // actual send - scheduled send (open-loop only)
 	Err        error
+	ErrorClass string
+	Bytes      int64  // response body size
+	Proto      string // protocol of the response, e.g. "HTTP/2.0"
+	Redirects  int    // redirects followed; Duration covers the whole chain
+	FinalURL   string // only set after redirects
+	FirstHop   time.Duration
+	Addr       string // IP of the server, empty if none was dialed
+	TLSVersion string // negotiated, e.g. "TLS 1.3"; empty over plain HTTP
+	TLSCipher  string
+	Phases     PhaseTimings
+	Stage      int
+	Checks     []CheckResult
+	BodySample string // start of the body, only kept when a check failed
 }
 
+// Error classes reported in ProbeStats.ErrorClasses
+const (
+	ErrClassDNS      = "dns"
+	ErrClassRefused  = "connection_refused"
+	ErrClassTimeout  = "timeout"
+	ErrClassTLS      = "tls"
+	ErrClassReset    = "reset"
+	ErrClassBlocked  = "blocked" // refused by AddrGuard or the target allowlist
+	ErrClassRedirect = "redirect"
+	ErrClassOther    = "other"
+)
+
 // Worker process targets from a channel and sends results back
 // OPTIMIZED: Minimal allocations, fast body drain
-func Worker(id int, targets <-chan string, results chan<- Result, client *http.Client, wg *sync.WaitGroup) {
+// Once ctx is done, queued targets are skipped and requests it interrupted
+// are not reported.
+func Worker(ctx context.Context, id int, targets <-chan Target, results chan<- Result, client *http.Client, wg *sync.WaitGroup) {
 	defer wg.Done()
-	// Reusable buffer for draining body
+	// Reusable buffers for draining body and for checks that read it
 	buf := make([]byte, 512)
-	
+	var body bytes.Buffer
+
 	for target := range targets {
-		// Normalize URL: add http:// if missing
-		url := target
-		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-			url = "http://" + url
+		if ctx.Err() != nil {
+			continue
 		}
-		
+		spec := target.Spec
 		start := time.Now()
-		req, err := http.NewRequest("HEAD", url, nil) // HEAD is faster than GET
+		measureFrom := start
+		if !target.Scheduled.IsZero() {
+			measureFrom = target.Scheduled
+		}
+
+		req, err := spec.NewRequest(ctx)
 		if err != nil {
-			results <- Result{URL: target, Err: err}
+			results <- Result{Name: spec.Name, URL: spec.URL, Start: measureFrom, Err: err, ErrorClass: ErrClassOther, Stage: target.Stage}
 			continue
 		}
-		
-		// Minimal headers for speed
-		req.Header.Set("User-Agent", "Mozilla/5.0")
-		req.Header.Set("Connection", "keep-alive")
-		
+
+		trace := &phaseTrace{start: start}
+		traceCtx := context.WithValue(req.Context(), phaseTraceKey{}, trace)
+		req = req.WithContext(httptrace.WithClientTrace(traceCtx, trace.ClientTrace()))
+
 		resp, err := client.Do(req)
-		duration := time.Since(start)
-		
+		duration := time.Since(measureFrom)
+		if err != nil && ctx.Err() != nil {
+			continue
+		}
+
 		res := Result{
-			URL:      target,
+			Name:     spec.Name,
+			URL:      spec.URL,
+			Addr:     trace.Remote(),
+			Start:    measureFrom,
 			Duration: duration,
+			Delay:    start.Sub(measureFrom),
 			Err:      err,
+			Stage:    target.Stage,
 		}
-		
+
 		if err == nil {
 			res.StatusCode = resp.StatusCode
-			// Fast body drain using small buffer
-			io.CopyBuffer(io.Discard, resp.Body, buf)
+			res.Proto = resp.Proto
+			if n, firstHop := trace.Redirects(); n > 0 {
+				res.Redirects = n
+				res.FinalURL = resp.Request.URL.String()
+				res.FirstHop = firstHop.Sub(measureFrom)
+			}
+			res.TLSVersion, res.TLSCipher = tlsLabel(resp.TLS)
+			if spec.Checks != nil {
+				var data []byte
+				res.Checks, data, res.Bytes = spec.Checks.Evaluate(resp, &body, buf, duration)
+				if !res.ChecksPassed() {
+					if len(data) > checkSampleBody {
+						data = data[:checkSampleBody]
+					}
+					res.BodySample = string(data)
+				}
+			} else {
+				// Fast body drain using small buffer
+				res.Bytes, _ = io.CopyBuffer(io.Discard, resp.Body, buf)
+			}
 			resp.Body.Close()
+			res.Phases = trace.Timings(time.Now())
+		} else {
+			res.ErrorClass = classifyError(err)
 		}
-		
+
 		results <- res
 	}
 }
+
+// ChecksPassed reports whether every check on the response succeeded.
+func (r Result) ChecksPassed() bool {
+	for _, c := range r.Checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// classifyError buckets a transport error returned by client.Do.
+func classifyError(err error) string {
+	if errors.Is(err, errBlockedAddr) || errors.Is(err, errRedirectRefused) {
+		return ErrClassBlocked
+	}
+	if errors.Is(err, errTooManyRedirects) {
+		return ErrClassRedirect
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrClassDNS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrClassRefused
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrClassReset
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrClassTimeout
+	}
+
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		strings.Contains(err.Error(), "tls: ") || strings.Contains(err.Error(), "HTTP response to HTTPS client") {
+		return ErrClassTLS
+	}
+
+	return ErrClassOther
+}